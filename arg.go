@@ -0,0 +1,40 @@
+package eval
+
+// Arg is argument of a function call passed to LazyFunctions. Argument expression is
+// evaluated on the first call of Value only, so functions can skip arguments they
+// do not need.
+type Arg struct {
+	expr    Expr
+	context Context
+	done    bool
+	value   interface{}
+	err     error
+}
+
+// Value evaluates the argument, expression is evaluated once and its result is reused
+// by further calls
+func (a *Arg) Value() (interface{}, error) {
+	if !a.done {
+		a.value, a.err = a.expr.Eval(a.context)
+		a.done = true
+	}
+	return a.value, a.err
+}
+
+// Expr returns expression of the argument
+func (a *Arg) Expr() Expr {
+	return a.expr
+}
+
+// EvalArgs evaluates all arguments and returns their values
+func EvalArgs(args []*Arg) ([]interface{}, error) {
+	list := make([]interface{}, len(args))
+	for i, a := range args {
+		v, err := a.Value()
+		if err != nil {
+			return nil, err
+		}
+		list[i] = v
+	}
+	return list, nil
+}
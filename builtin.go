@@ -125,7 +125,7 @@ func builtin(name string, args []interface{}, context Context) (val interface{},
 		case string:
 			return v1.(string), nil
 		case *big.Rat:
-			return v1.(*big.Rat).String(), nil
+			return numberString(v1.(*big.Rat)), nil
 		case bool:
 			if v1.(bool) {
 				return "true", nil
@@ -133,6 +133,9 @@ func builtin(name string, args []interface{}, context Context) (val interface{},
 				return "false", nil
 			}
 		case time.Time:
+			if IsDate(v1.(time.Time)) {
+				return v1.(time.Time).Format("2006-01-02"), nil
+			}
 			return v1.(time.Time).Format(ISO8601), nil
 		}
 		panic(fmt.Sprint("unsupported type:", v1))
@@ -180,7 +183,7 @@ func builtin(name string, args []interface{}, context Context) (val interface{},
 		n1 := GetNumberAsInt(args, 0)
 		n2 := GetNumberAsInt(args, 1)
 		n3 := GetNumberAsInt(args, 2)
-		return time.Date(n1, time.Month(n2), n3, 0, 0, 0, 0, context.cast().localTimeZone), nil
+		return Date(n1, time.Month(n2), n3), nil
 	case "DATEVALUE":
 		MinNumOfParams(args, 1)
 		s1 := MustBeString(args, 0)
@@ -189,7 +192,11 @@ func builtin(name string, args []interface{}, context Context) (val interface{},
 			NumOfParams(args, 2)
 			s2 = MustBeString(args, 1)
 		}
-		return context.ParseDate(s2, s1)
+		t, err := context.ParseDate(s2, s1)
+		if err != nil {
+			return nil, err
+		}
+		return Date(t.Year(), t.Month(), t.Day()), nil
 	case "DATETIMEVALUE":
 		MinNumOfParams(args, 1)
 		s1 := MustBeString(args, 0)
@@ -212,7 +219,8 @@ func builtin(name string, args []interface{}, context Context) (val interface{},
 		return time.Now().In(context.cast().localTimeZone), nil
 	case "TODAY":
 		NumOfParams(args, 0)
-		return time.Now().In(context.cast().localTimeZone).Truncate(time.Hour * 24), nil
+		now := time.Now().In(context.cast().localTimeZone)
+		return Date(now.Year(), now.Month(), now.Day()), nil
 	case "YEAR":
 		NumOfParams(args, 1)
 		d1 := MustBeDate(args, 0)
@@ -249,9 +257,11 @@ func builtin(name string, args []interface{}, context Context) (val interface{},
 		NumOfParams(args, 2)
 		n1 := MustBeNumber(args, 0)
 		n2 := MustBeNumber(args, 1)
-		q := new(big.Rat).Quo(n1, n2)
-		r := new(big.Int).Quo(q.Num(), q.Denom())
-		return new(big.Rat).Sub(n1, new(big.Rat).Mul(n2, q.SetInt(r))), nil
+		r, err := mod(n1, n2)
+		if err != nil {
+			return context.cast().divisionByZero()
+		}
+		return r, nil
 	case "MIN":
 		var nm *big.Rat
 		for i := 0; i < len(args); i++ {
@@ -290,46 +300,12 @@ func builtin(name string, args []interface{}, context Context) (val interface{},
 			}
 		}
 		return nm, nil
-	// salesforce logical functions: AND, NOT, OR should not be used, use logical operators
-	case "CASE":
-		MinNumOfParams(args, 3)
-		i := 1
-		for ; i < len(args)-1; i += 2 {
-			switch args[0].(type) {
-			case nil:
-				if args[i] == nil {
-					return args[i+1], nil
-				}
-			case *big.Rat:
-				n := MustBeNumber(args, i)
-				if args[0].(*big.Rat).Cmp(n) == 0 {
-					return args[i+1], nil
-				}
-			case string:
-				s := MustBeString(args, i)
-				if args[0].(string) == s {
-					return args[i+1], nil
-				}
-			case bool:
-				b1 := MustBeBool(args, i)
-				if args[0].(bool) == b1 {
-					return args[i+1], nil
-				}
-			default:
-				panic(fmt.Sprint("unsupported type:", args[i]))
-			}
-		}
-		if i < len(args) {
-			return args[i], nil
-		}
-		panic("missing default value")
-	case "IF":
-		NumOfParams(args, 3)
-		cond := MustBeBool(args, 0)
-		if cond {
-			return args[1], nil
-		}
-		return args[2], nil
+	// salesforce logical functions: AND, NOT, OR are provided for formulas written in
+	// Salesforce, logical operators should be preferred. AND and OR are lazy.
+	case "NOT":
+		NumOfParams(args, 1)
+		return !MustBeBool(args, 0), nil
+	// CASE and IF are lazy, see lazyBuiltins
 	// salesforce informational functions: BLANKVALUE, NULLVALUE, ISBLANK. ISNULL
 	// there is no PRIORVALUE
 	case "ISBLANK", "ISNULL":
@@ -340,14 +316,7 @@ func builtin(name string, args []interface{}, context Context) (val interface{},
 			return true, nil
 		}
 		return false, nil
-	case "NULLVALUE", "BLANKVALUE":
-		NumOfParams(args, 2)
-		if args[0] == nil {
-			return args[1], nil
-		} else if s, ok := args[0].(string); ok && s == "" {
-			return args[1], nil
-		}
-		return args[0], nil
+	// NULLVALUE and BLANKVALUE are lazy, see lazyBuiltins
 	// additional convenience functions for text
 	// join(delimiter, strings...) joins non empty strings listed as arguments using delimiter (empty strings are skipped)
 	case "JOIN":
@@ -366,6 +335,135 @@ func builtin(name string, args []interface{}, context Context) (val interface{},
 	panic("unknown function")
 }
 
+// lazyBuiltins are builtin functions which evaluate arguments only when needed. They
+// can not be overridden by Functions, only by LazyFunctions.
+var lazyBuiltins = map[string]func(args []*Arg, context Context) (interface{}, error){
+	"AND": func(args []*Arg, context Context) (interface{}, error) {
+		return logical(args, false)
+	},
+	"OR": func(args []*Arg, context Context) (interface{}, error) {
+		return logical(args, true)
+	},
+	"IF": func(args []*Arg, context Context) (interface{}, error) {
+		NumOfArgs(args, 3)
+		list := make([]interface{}, len(args))
+		if err := argValue(args, list, 0); err != nil {
+			return nil, err
+		}
+		if MustBeBool(list, 0) {
+			return args[1].Value()
+		}
+		return args[2].Value()
+	},
+	"CASE": func(args []*Arg, context Context) (interface{}, error) {
+		MinNumOfArgs(args, 3)
+		list := make([]interface{}, len(args))
+		if err := argValue(args, list, 0); err != nil {
+			return nil, err
+		}
+		i := 1
+		for ; i < len(args)-1; i += 2 {
+			if err := argValue(args, list, i); err != nil {
+				return nil, err
+			}
+			switch list[0].(type) {
+			case nil:
+				if list[i] == nil {
+					return args[i+1].Value()
+				}
+			case *big.Rat:
+				n := MustBeNumber(list, i)
+				if list[0].(*big.Rat).Cmp(n) == 0 {
+					return args[i+1].Value()
+				}
+			case string:
+				s := MustBeString(list, i)
+				if list[0].(string) == s {
+					return args[i+1].Value()
+				}
+			case bool:
+				b1 := MustBeBool(list, i)
+				if list[0].(bool) == b1 {
+					return args[i+1].Value()
+				}
+			default:
+				panic(fmt.Sprint("unsupported type:", list[i]))
+			}
+		}
+		if i < len(args) {
+			return args[i].Value()
+		}
+		panic("missing default value")
+	},
+	"NULLVALUE":  blankValue,
+	"BLANKVALUE": blankValue,
+	// higher order functions taking lambdas, see lambda.go
+	"MAP":        mapList,
+	"FILTER":     filterList,
+	"ANY":        quantifier(true),
+	"ALL":        quantifier(false),
+	"FIND_FIRST": findFirst,
+	"REDUCE":     reduce,
+	"SORT_BY":    sortBy,
+	"LET":        let,
+}
+
+// let(name1, value1, name2, value2, ..., body) evaluates body with names bound to values,
+// each value is evaluated once and may refer to names bound before it
+func let(args []*Arg, context Context) (interface{}, error) {
+	if len(args) < 3 || len(args)%2 == 0 {
+		panic("expected pairs of name and value followed by body")
+	}
+	c := context.cast()
+	for i := 0; i < len(args)-1; i += 2 {
+		name, ok := args[i].Expr().(*Ident)
+		if !ok {
+			panic(fmt.Sprint("parameter ", i, " not a name"))
+		}
+		v, err := args[i+1].Expr().Eval(c)
+		if err != nil {
+			return nil, err
+		}
+		c = c.with(map[string]interface{}{name.name: v})
+	}
+	return args[len(args)-1].Expr().Eval(c)
+}
+
+func blankValue(args []*Arg, context Context) (interface{}, error) {
+	NumOfArgs(args, 2)
+	v, err := args[0].Value()
+	if err != nil {
+		return nil, err
+	}
+	if v == nil {
+		return args[1].Value()
+	} else if s, ok := v.(string); ok && s == "" {
+		return args[1].Value()
+	}
+	return v, nil
+}
+
+// logical evaluates arguments until the first one equal to stop, which is the result
+func logical(args []*Arg, stop bool) (interface{}, error) {
+	MinNumOfArgs(args, 1)
+	list := make([]interface{}, len(args))
+	for i := range args {
+		if err := argValue(args, list, i); err != nil {
+			return nil, err
+		}
+		if MustBeBool(list, i) == stop {
+			return stop, nil
+		}
+	}
+	return !stop, nil
+}
+
+// argValue evaluates argument into list, so that helpers like MustBeBool can be used
+func argValue(args []*Arg, list []interface{}, index int) (err error) {
+	list[index], err = args[index].Value()
+	return err
+}
+
 func substr(s string, b int, l int) string {
 	r := []rune(s)
 	if len(r) == 0 || l == 0 {
@@ -387,3 +485,30 @@ func substr(s string, b int, l int) string {
 	}
 	return string(r[b:e])
 }
+
+// numberString returns decimal representation of the number. Numbers with terminating
+// decimal expansion are printed exactly, others are rounded to 20 decimal places.
+func numberString(r *big.Rat) string {
+	if r.IsInt() {
+		return r.Num().String()
+	}
+	// number of decimal places is the larger of exponents of 2 and 5 in the denominator
+	d := new(big.Int).Set(r.Denom())
+	two, five, m := big.NewInt(2), big.NewInt(5), new(big.Int)
+	n2, n5 := 0, 0
+	for m.Mod(d, two).Sign() == 0 {
+		d.Quo(d, two)
+		n2++
+	}
+	for m.Mod(d, five).Sign() == 0 {
+		d.Quo(d, five)
+		n5++
+	}
+	if d.Cmp(big.NewInt(1)) != 0 {
+		return strings.TrimRight(strings.TrimRight(r.FloatString(20), "0"), ".")
+	}
+	if n5 > n2 {
+		n2 = n5
+	}
+	return r.FloatString(n2)
+}
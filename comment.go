@@ -0,0 +1,37 @@
+package eval
+
+// Comment is comment found in the source, Text includes comment markers: // for line
+// comments and /* */ for block comments
+type Comment struct {
+	Text string
+}
+
+// commentSet is embedded in every node to keep comments attached to the node
+type commentSet struct {
+	leading  []Comment
+	trailing []Comment
+}
+
+// Leading returns comments found in the source right before the node
+func (c *commentSet) Leading() []Comment {
+	return c.leading
+}
+
+// Trailing returns comments found in the source right after the node
+func (c *commentSet) Trailing() []Comment {
+	return c.trailing
+}
+
+func (c *commentSet) comments() *commentSet {
+	return c
+}
+
+// commented is implemented by all nodes
+type commented interface {
+	comments() *commentSet
+}
+
+// isLineComment tells if comment runs up to the end of line
+func (c Comment) isLineComment() bool {
+	return len(c.Text) > 1 && c.Text[1] == '/'
+}
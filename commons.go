@@ -4,3 +4,6 @@ import ()
 
 type Values func(string) (interface{}, bool)
 type Functions func(string, []interface{}) (interface{}, error)
+
+// LazyFunctions are like Functions but get arguments unevaluated, see Arg
+type LazyFunctions func(string, []*Arg) (interface{}, error)
@@ -1,24 +1,70 @@
 package eval
 
 import (
+	"math/big"
 	"strings"
 	"time"
 )
 
 type Context interface {
 	AddFunctions(Functions) Context
+	AddLazyFunctions(LazyFunctions) Context
 	AddValues(Values) Context
 	SetTimeZone(*time.Location) Context
+	SetZeroDivision(mode ZeroDivision, value *big.Rat) Context
+	SetStrict(bool) Context
+	SetFloatMode(mode FloatMode, places int) Context
+	Define(name string, params []string, body string) error
 	ParseDate(format, value string) (time.Time, error)
 	cast() *context
 }
 
 type context struct {
+	lazyFunctions []LazyFunctions
 	functions     []Functions
 	values        []Values
 	localTimeZone *time.Location
+	zeroDivision  ZeroDivision
+	zeroValue     *big.Rat
+	strict        bool // index out of range or missing key is an error
+	floatMode     FloatMode
+	floatPlaces   int    // places of rounding float modes
+	scope         *scope // parameters of lambdas, they shadow values
+	defined       map[string]*definition
+	active        *active // defined functions being evaluated
 }
 
+// scope maps names to values, names of inner scopes shadow names of outer scopes
+type scope struct {
+	outer  *scope
+	values map[string]interface{}
+}
+
+func (s *scope) lookup(name string) (interface{}, bool) {
+	for ; s != nil; s = s.outer {
+		if v, ok := s.values[name]; ok {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// with returns copy of context with inner scope of values
+func (context *context) with(values map[string]interface{}) *context {
+	c := *context
+	c.scope = &scope{outer: context.scope, values: values}
+	return &c
+}
+
+// ZeroDivision selects result of division by zero
+type ZeroDivision int
+
+const (
+	ZeroDivisionFails   ZeroDivision = iota // DivisionByZeroError is returned, the default
+	ZeroDivisionNull                        // result is null
+	ZeroDivisionDefault                     // result is the value given to SetZeroDivision
+)
+
 func NewContext() *context {
 	return &context{functions: make([]Functions, 0, 5), values: make([]Values, 0, 5), localTimeZone: time.Now().Location()}
 }
@@ -27,6 +73,8 @@ func (context *context) cast() *context {
 	return context
 }
 
+// AddFunctions adds functions which get evaluated arguments, they can override builtins
+// except lazy builtins like IF, which are overridden only by LazyFunctions.
 func (context *context) AddFunctions(functions Functions) Context {
 	if context == nil {
 		context = NewContext()
@@ -37,6 +85,18 @@ func (context *context) AddFunctions(functions Functions) Context {
 	return context
 }
 
+// AddLazyFunctions adds functions which get arguments unevaluated. They are tried before
+// Functions and can override lazy builtins like IF.
+func (context *context) AddLazyFunctions(functions LazyFunctions) Context {
+	if context == nil {
+		context = NewContext()
+	}
+	if functions != nil {
+		context.lazyFunctions = append(context.lazyFunctions, functions)
+	}
+	return context
+}
+
 func (context *context) AddValues(values Values) Context {
 	if context == nil {
 		context = NewContext()
@@ -57,6 +117,54 @@ func (context *context) SetTimeZone(location *time.Location) Context {
 	return context
 }
 
+// SetZeroDivision sets result of division by zero, of MOD with zero divisor and of zero
+// raised to a negative power. Value is used only with ZeroDivisionDefault.
+func (context *context) SetZeroDivision(mode ZeroDivision, value *big.Rat) Context {
+	if context == nil {
+		context = NewContext()
+	}
+	context.zeroDivision = mode
+	context.zeroValue = value
+	return context
+}
+
+// SetStrict sets whether index out of range of list or missing key of map is an error,
+// by default it gives null
+func (context *context) SetStrict(strict bool) Context {
+	if context == nil {
+		context = NewContext()
+	}
+	context.strict = strict
+	return context
+}
+
+// SetFloatMode sets conversion of Go floats given by Values and Functions to numbers.
+// Floats are converted exactly by default, FloatHalfUp, FloatHalfEven and FloatDown
+// round the exact value to places. Fields of records are converted by their shortest
+// decimal representation in any mode, see Record.
+func (context *context) SetFloatMode(mode FloatMode, places int) Context {
+	if context == nil {
+		context = NewContext()
+	}
+	context.floatMode = mode
+	context.floatPlaces = places
+	return context
+}
+
+// divisionByZero returns result of division by zero according to the context settings
+func (context *context) divisionByZero() (interface{}, error) {
+	switch context.zeroDivision {
+	case ZeroDivisionNull:
+		return nil, nil
+	case ZeroDivisionDefault:
+		if context.zeroValue == nil {
+			return nil, nil
+		}
+		return new(big.Rat).Set(context.zeroValue), nil
+	}
+	return nil, DivisionByZeroError{}
+}
+
 func (context *context) ParseDate(format, value string) (time.Time, error) {
 	layout := format
 	if !strings.HasPrefix(layout, "2006") {
@@ -0,0 +1,294 @@
+package eval
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// definition is function defined by formula, see Define
+type definition struct {
+	name   string
+	params []string
+	body   Expr
+}
+
+// active is chain of calls of defined functions being evaluated
+type active struct {
+	outer *active
+	name  string
+}
+
+// Define defines function name(params...) evaluating formula body written in the standard
+// dialect. Body is parsed and checked once: syntax errors, operators applied to operands of
+// wrong type like 'x' * 2, calls of already defined functions with wrong number of
+// arguments and recursive calls are reported. Defined functions are called after Functions
+// of the context and before builtins, so they can override builtins except the lazy ones.
+// Body sees parameters and values of the context, not names bound where the function is
+// called. Recursion through other defined functions is an error when evaluated.
+// Unlike setters of Context, Define returns error and not the context to be chained: body
+// is checked against functions defined before, so errors must be seen at once, not
+// when the context is used. Define is called on the context after chained setters:
+//
+//	context := NewContext().AddValues(values)
+//	err := context.Define("net", []string{"x"}, "x * (1 - tax)")
+func (context *context) Define(name string, params []string, body string) error {
+	if !isName(name) {
+		return errors.New("illegal function name: '" + name + "'")
+	}
+	seen := make(map[string]bool, len(params))
+	for _, p := range params {
+		if !isName(p) {
+			return errors.New("illegal parameter name: '" + p + "' in " + name)
+		}
+		if seen[p] {
+			return errors.New("duplicate parameter " + p + " in " + name)
+		}
+		seen[p] = true
+	}
+	x, err := Parse([]byte(body))
+	if err != nil {
+		return err
+	}
+	name = strings.ToUpper(name)
+	c := &checker{name: name, defined: context.defined}
+	if _, err := c.check(x); err != nil {
+		return errors.New(fmt.Sprint("error in definition of ", name, ": ", err))
+	}
+	if context.defined == nil {
+		context.defined = make(map[string]*definition)
+	}
+	context.defined[name] = &definition{name: name, params: params, body: x}
+	return nil
+}
+
+// isName returns whether s can be written as identifier without curly braces
+func isName(s string) bool {
+	for i, ch := range s {
+		if !isLetter(ch) && (i == 0 || !isDigit(ch) && ch != '_') {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// call evaluates body of defined function in a new scope of parameters
+func (d *definition) call(context *context, args []interface{}) (interface{}, error) {
+	if len(args) != len(d.params) {
+		return nil, errors.New(fmt.Sprint(d.name, " expects ", len(d.params), " parameters, actual ", len(args)))
+	}
+	for a := context.active; a != nil; a = a.outer {
+		if a.name == d.name {
+			return nil, errors.New("recursive call of " + d.name)
+		}
+	}
+	values := make(map[string]interface{}, len(args))
+	for i, p := range d.params {
+		values[p] = args[i]
+	}
+	c := *context
+	c.scope = &scope{values: values}
+	c.active = &active{outer: context.active, name: d.name}
+	return d.body.Eval(&c)
+}
+
+// kind is type of value known before evaluation
+type kind int
+
+const (
+	anyKind kind = iota // not known before evaluation
+	numberKind
+	textKind
+	booleanKind
+	dateKind
+	listKind
+)
+
+func (k kind) String() string {
+	return [...]string{"any", "number", "text", "boolean", "date", "list"}[k]
+}
+
+// kindOf returns kind of literal value
+func kindOf(v interface{}) kind {
+	switch v.(type) {
+	case *big.Rat:
+		return numberKind
+	case string:
+		return textKind
+	case bool:
+		return booleanKind
+	case time.Time:
+		return dateKind
+	case []interface{}:
+		return listKind
+	}
+	return anyKind
+}
+
+// checker finds errors in body of function which would fail any evaluation. Values of
+// identifiers and results of calls are not known, they are of any kind.
+type checker struct {
+	name    string // name of the defined function
+	defined map[string]*definition
+}
+
+func (c *checker) check(e Expr) (kind, error) {
+	switch n := e.(type) {
+	case *Literal:
+		return kindOf(n.value), nil
+	case *List:
+		for _, x := range n.elems {
+			if _, err := c.check(x); err != nil {
+				return anyKind, err
+			}
+		}
+		return listKind, nil
+	case *Unary:
+		k, err := c.check(n.x)
+		if err != nil {
+			return anyKind, err
+		}
+		want := numberKind
+		if n.op == NOT {
+			want = booleanKind
+		}
+		return want, expect(e, k, want)
+	case *Binary:
+		return c.checkBinary(n)
+	case *Like:
+		for _, x := range []Expr{n.x, n.pattern, n.escape} {
+			if x == nil {
+				continue
+			}
+			k, err := c.check(x)
+			if err == nil {
+				err = expect(e, k, textKind)
+			}
+			if err != nil {
+				return anyKind, err
+			}
+		}
+		return booleanKind, nil
+	case *Conditional:
+		k, err := c.check(n.cond)
+		if err == nil {
+			err = expect(e, k, booleanKind)
+		}
+		if err != nil {
+			return anyKind, err
+		}
+		kt, err := c.check(n.then)
+		if err != nil {
+			return anyKind, err
+		}
+		ke, err := c.check(n.els)
+		if err != nil || kt != ke {
+			return anyKind, err
+		}
+		return kt, nil
+	case *Call:
+		if n.name == c.name {
+			return anyKind, errors.New("recursive call of " + n.name)
+		}
+		if d, ok := c.defined[n.name]; ok && len(d.params) != len(n.args) {
+			return anyKind, errors.New(fmt.Sprint(n.name, " expects ", len(d.params), " parameters, actual ", len(n.args)))
+		}
+		for _, x := range n.args {
+			if _, err := c.check(x); err != nil {
+				return anyKind, err
+			}
+		}
+	case *Lambda:
+		_, err := c.check(n.body)
+		return anyKind, err
+	case *Selector:
+		_, err := c.check(n.x)
+		return anyKind, err
+	case *Index:
+		if _, err := c.check(n.x); err != nil {
+			return anyKind, err
+		}
+		_, err := c.check(n.index)
+		return anyKind, err
+	}
+	return anyKind, nil
+}
+
+func (c *checker) checkBinary(e *Binary) (kind, error) {
+	kx, err := c.check(e.x)
+	if err != nil {
+		return anyKind, err
+	}
+	ky, err := c.check(e.y)
+	if err != nil {
+		return anyKind, err
+	}
+	both := func(want kind) (kind, error) {
+		if err := expect(e, kx, want); err != nil {
+			return anyKind, err
+		}
+		return want, expect(e, ky, want)
+	}
+	switch e.op {
+	case AND, OR:
+		return both(booleanKind)
+	case CONCAT:
+		return both(textKind)
+	case MUL, DIV, POW, MOD:
+		return both(numberKind)
+	case IN, NOTIN:
+		return booleanKind, expect(e, ky, listKind)
+	case COALESCE:
+		if kx == ky {
+			return kx, nil
+		}
+	case EQ, NEQ, LT, LTE, GT, GTE:
+		if kx != anyKind && ky != anyKind && kx != ky {
+			return anyKind, mismatch(e, kx, ky)
+		}
+		return booleanKind, nil
+	case ADD, SUB:
+		switch {
+		case kx == anyKind && ky == anyKind:
+			return anyKind, nil
+		case kx == dateKind && ky == dateKind:
+			if e.op == ADD {
+				return anyKind, mismatch(e, kx, ky)
+			}
+			return numberKind, nil
+		case kx == dateKind:
+			return dateKind, expect(e, ky, numberKind)
+		case ky == dateKind:
+			if e.op == SUB {
+				return anyKind, mismatch(e, kx, ky)
+			}
+			return dateKind, expect(e, kx, numberKind)
+		case kx == textKind || ky == textKind:
+			if e.op == SUB {
+				return anyKind, mismatch(e, kx, ky)
+			}
+			return both(textKind)
+		case kx == numberKind && ky == numberKind:
+			return numberKind, nil
+		case kx == booleanKind || kx == listKind:
+			return anyKind, mismatch(e, kx, ky)
+		case ky == booleanKind || ky == listKind:
+			return anyKind, mismatch(e, kx, ky)
+		}
+	}
+	return anyKind, nil
+}
+
+// expect returns error if kind k of operand of e is known and it is not the wanted one
+func expect(e Expr, k, want kind) error {
+	if k == anyKind || k == want {
+		return nil
+	}
+	return errors.New(fmt.Sprint(k, " where ", want, " expected: ", Format(e)))
+}
+
+func mismatch(e Expr, kx, ky kind) error {
+	return errors.New(fmt.Sprint("operands of ", kx, " and ", ky, " kind: ", Format(e)))
+}
@@ -0,0 +1,55 @@
+package eval
+
+// Dialect selects syntax accepted by the parser
+type Dialect int
+
+const (
+	// Standard syntax uses == for equality and has no & operator
+	Standard Dialect = iota
+	// Salesforce accepts formulas as written in Salesforce: = is equality, & is string
+	// concatenation, ^ is exponentiation and names of global variables like $User.Id
+	// start with $.
+	Salesforce
+)
+
+// Parse parses source written in the dialect, see Parse
+func (d Dialect) Parse(src []byte) (Expr, error) {
+	expr, errs := parse(src, false, d)
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+	return expr, nil
+}
+
+// ParseString parses string written in the dialect, see ParseString
+func (d Dialect) ParseString(src string) (Expr, error) {
+	return d.Parse([]byte(src))
+}
+
+// ParseAll parses source written in the dialect reporting all syntax errors, see ParseAll
+func (d Dialect) ParseAll(src []byte) (Expr, []*ParseError) {
+	return parse(src, true, d)
+}
+
+// ParseScript parses script written in the dialect, see ParseScript
+func (d Dialect) ParseScript(src []byte) (*Script, error) {
+	return parseScript(src, d)
+}
+
+// ParseTemplate parses template with placeholders written in the dialect, see ParseTemplate
+func (d Dialect) ParseTemplate(src string) (*Template, error) {
+	return parseTemplate(src, d)
+}
+
+// repr returns representation of operator in the dialect
+func (d Dialect) repr(tok Token) string {
+	if d == Salesforce {
+		switch tok {
+		case EQ:
+			return "="
+		case NEQ:
+			return "<>"
+		}
+	}
+	return repr(tok)
+}
@@ -0,0 +1,123 @@
+package eval
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// DivisionByZeroError is returned when a number is divided by zero, unless the context
+// is set to return a value instead, see SetZeroDivision
+type DivisionByZeroError struct{}
+
+func (e DivisionByZeroError) Error() string {
+	return "division by zero"
+}
+
+// ParseError describes a syntax error found while scanning or parsing an expression.
+// Line and Column are 1-based, Column counts characters (runes), Offset and Length are
+// measured in bytes of the source.
+type ParseError struct {
+	Message  string
+	Line     int
+	Column   int
+	Offset   int
+	Length   int
+	Expected []string // descriptions of tokens which would have been accepted, if known
+	text     string   // source line containing the error
+	width    int      // number of characters of the offending token on that line
+}
+
+func newParseError(src []byte, offset, length int, msg string, expected []string) *ParseError {
+	if offset > len(src) {
+		offset = len(src)
+	}
+	if offset+length > len(src) {
+		length = len(src) - offset
+	}
+	start := bytes.LastIndexByte(src[:offset], '\n') + 1
+	end := bytes.IndexByte(src[offset:], '\n')
+	if end < 0 {
+		end = len(src)
+	} else {
+		end += offset
+	}
+	tokenEnd := offset + length
+	if tokenEnd > end {
+		tokenEnd = end
+	}
+	line, column := position(src, offset)
+	return &ParseError{
+		Message:  msg,
+		Line:     line,
+		Column:   column,
+		Offset:   offset,
+		Length:   length,
+		Expected: expected,
+		text:     strings.TrimRight(string(src[start:end]), "\r"),
+		width:    utf8.RuneCount(src[offset:tokenEnd]),
+	}
+}
+
+// position returns 1-based line and column of offset in the source, column counts
+// characters
+func position(src []byte, offset int) (line, column int) {
+	start := bytes.LastIndexByte(src[:offset], '\n') + 1
+	return bytes.Count(src[:offset], []byte{'\n'}) + 1, utf8.RuneCount(src[start:offset]) + 1
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprint(e.Line, ":", e.Column, ": ", e.Message)
+}
+
+// Snippet returns the source line containing the error with a caret marker
+// underneath the offending token, e.g.
+//
+//	LEFT(name, 3
+//	            ^
+func (e *ParseError) Snippet() string {
+	var b strings.Builder
+	b.WriteString(e.text)
+	b.WriteByte('\n')
+	col := 1
+	for _, r := range e.text {
+		if col >= e.Column {
+			break
+		}
+		// keep tabs so that the marker lines up with the source line
+		if r == '\t' {
+			b.WriteByte('\t')
+		} else {
+			b.WriteByte(' ')
+		}
+		col++
+	}
+	for ; col < e.Column; col++ {
+		b.WriteByte(' ')
+	}
+	if e.width > 1 {
+		b.WriteString(strings.Repeat("^", e.width))
+	} else {
+		b.WriteByte('^')
+	}
+	return b.String()
+}
+
+// TemplateError describes failed evaluation of template placeholder, see Template.Render.
+// Line and Column are 1-based position of the placeholder, Offset is measured in bytes.
+type TemplateError struct {
+	Placeholder string // source of the placeholder including braces
+	Line        int
+	Column      int
+	Offset      int
+	Err         error
+}
+
+func (e *TemplateError) Error() string {
+	return fmt.Sprint(e.Line, ":", e.Column, ": error in ", e.Placeholder, ": ", e.Err)
+}
+
+func (e *TemplateError) Unwrap() error {
+	return e.Err
+}
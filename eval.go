@@ -3,111 +3,435 @@ package eval
 import (
 	"errors"
 	"fmt"
+	"math"
 	"math/big"
+	"regexp"
+	"strings"
 	"time"
+	"unicode/utf8"
 )
 
+// Expr is parsed expression. Nodes of the expression tree are *Ident, *Literal, *Call,
+// *Selector, *Index, *List, *Lambda, *Script, *Assign, *Unary, *Binary, *Like,
+// *Conditional and *BadExpr. All nodes have Leading and Trailing methods returning
+// comments attached to the node.
 type Expr interface {
 	Eval(Context) (interface{}, error)
 	String() string
 }
 
 // use after calls to values or functions to ensure that no illegal types have been returned
-func validate(v interface{}, name string) (interface{}, error) {
-	if v == nil {
-		return v, nil
+func validate(v interface{}, name string, context Context) (interface{}, error) {
+	if x, ok := normalize(v, context.cast()); ok {
+		return x, nil
 	}
-	switch v.(type) {
-	case string:
-	case *big.Rat:
-	case bool:
-	case time.Time:
-	default:
-		if name == "" {
-			return nil, errors.New("illegal value: '" + fmt.Sprint(v) + "'")
-		} else {
-			return nil, errors.New("illegal value: '" + fmt.Sprint(v) + "' in " + name)
-		}
+	if name == "" {
+		return nil, errors.New("illegal value: '" + fmt.Sprint(v) + "'")
+	} else {
+		return nil, errors.New("illegal value: '" + fmt.Sprint(v) + "' in " + name)
 	}
-	return v, nil
 }
 
-type ident struct {
+// Ident is reference to value provided by context
+type Ident struct {
+	commentSet
 	name string
 }
 
-func (e *ident) Eval(context Context) (interface{}, error) {
+// Name returns name of the referenced value
+func (e *Ident) Name() string {
+	return e.name
+}
+
+func (e *Ident) Eval(context Context) (interface{}, error) {
+	v, ok, err := e.lookup(context)
+	if !ok {
+		return nil, errors.New("unknown value: " + e.name)
+	}
+	return v, err
+}
+
+// lookup returns value of the name and whether it is resolved by scope or values
+func (e *Ident) lookup(context Context) (interface{}, bool, error) {
+	if v, ok := context.cast().scope.lookup(e.name); ok {
+		return v, true, nil
+	}
 	for _, fn := range context.cast().values {
 		if v, ok := fn(e.name); ok {
-			return validate(v, e.name)
+			v, err := validate(v, e.name, context)
+			return v, true, err
 		}
 	}
-	return nil, errors.New("unknown value: " + e.name)
+	return nil, false, nil
 }
 
-func (e ident) String() string {
-	return e.name
+func (e *Ident) String() string {
+	return Format(e)
+}
+
+// Selector is selection of field of record x.sel, see Record, or null-safe selection
+// x?.sel which gives null also if x is unknown name
+type Selector struct {
+	commentSet
+	x    Expr
+	sel  string
+	safe bool
 }
 
-type literal struct {
+// X returns expression giving record
+func (e *Selector) X() Expr {
+	return e.x
+}
+
+// Sel returns name of selected field
+func (e *Selector) Sel() string {
+	return e.sel
+}
+
+// Safe returns whether selection is null-safe x?.sel
+func (e *Selector) Safe() bool {
+	return e.safe
+}
+
+// Eval returns value of dotted name like Account.Name if Values of context resolve it,
+// otherwise it selects field of evaluated record. Field of null is null, x?.sel is null
+// also if x is unknown name.
+func (e *Selector) Eval(context Context) (interface{}, error) {
+	if name, ok := path(e); ok && !shadowed(e, context) {
+		for _, fn := range context.cast().values {
+			if v, ok := fn(name); ok {
+				return validate(v, name, context)
+			}
+		}
+	}
+	var x interface{}
+	var err error
+	if id, ok := e.x.(*Ident); ok && e.safe {
+		x, _, err = id.lookup(context) // unknown name is null
+	} else {
+		x, err = e.x.Eval(context)
+	}
+	if x == nil || err != nil {
+		return nil, err
+	}
+	if !isRecord(x) {
+		return nil, errors.New("not a record:" + fmt.Sprint(x))
+	}
+	v, ok := field(x, e.sel)
+	if !ok {
+		return nil, errors.New("unknown field: " + e.sel)
+	}
+	return validate(v, e.sel, context)
+}
+
+func (e *Selector) String() string {
+	return Format(e)
+}
+
+// Index is element of list x[index] or value of key of map x['key']. Negative index
+// counts from the end of list, index out of range or missing key gives null unless the
+// context is strict, see SetStrict.
+type Index struct {
+	commentSet
+	x     Expr
+	index Expr
+}
+
+// X returns indexed expression
+func (e *Index) X() Expr {
+	return e.x
+}
+
+// Index returns index or key
+func (e *Index) Index() Expr {
+	return e.index
+}
+
+func (e *Index) Eval(context Context) (interface{}, error) {
+	x, err := e.x.Eval(context)
+	if err != nil {
+		return nil, err
+	}
+	i, err := e.index.Eval(context)
+	if err != nil {
+		return nil, err
+	}
+	if x == nil || i == nil {
+		return nil, nil
+	}
+	strict := context.cast().strict
+	if list, ok := x.([]interface{}); ok {
+		n, ok := i.(*big.Rat)
+		if !ok {
+			return nil, errors.New("not a number:" + fmt.Sprint(i))
+		}
+		if !n.IsInt() {
+			return nil, errors.New("index not an integer: " + numberString(n))
+		}
+		k := n.Num().Int64()
+		if k < 0 {
+			k += int64(len(list))
+		}
+		if !n.Num().IsInt64() || k < 0 || k >= int64(len(list)) {
+			if strict {
+				return nil, errors.New(fmt.Sprint("index out of range: ", numberString(n), " of ", len(list)))
+			}
+			return nil, nil
+		}
+		return list[k], nil
+	}
+	if !isRecord(x) {
+		return nil, errors.New("not a list or record:" + fmt.Sprint(x))
+	}
+	key, ok := i.(string)
+	if !ok {
+		return nil, errors.New("not a string:" + fmt.Sprint(i))
+	}
+	if !hasField(x, key) {
+		if strict {
+			return nil, errors.New("unknown key: " + key)
+		}
+		return nil, nil
+	}
+	v, _ := field(x, key)
+	return validate(v, key, context)
+}
+
+func (e *Index) String() string {
+	return Format(e)
+}
+
+// shadowed returns whether identifier at the root of selectors is in scope, dotted name
+// must not be looked up in values then
+func shadowed(e Expr, context Context) bool {
+	for {
+		switch x := e.(type) {
+		case *Selector:
+			e = x.x
+		case *Ident:
+			_, ok := context.cast().scope.lookup(x.name)
+			return ok
+		default:
+			return false
+		}
+	}
+}
+
+// path returns dotted name of identifier or selectors of identifier
+func path(e Expr) (string, bool) {
+	switch x := e.(type) {
+	case *Ident:
+		return x.name, true
+	case *Selector:
+		if name, ok := path(x.x); ok {
+			return name + "." + x.sel, true
+		}
+	}
+	return "", false
+}
+
+// Literal is constant string, number (*big.Rat), boolean or nil value
+type Literal struct {
+	commentSet
 	value interface{}
 }
 
-func (e *literal) Eval(context Context) (interface{}, error) {
+// Value returns value of the literal
+func (e *Literal) Value() interface{} {
+	return e.value
+}
+
+func (e *Literal) Eval(context Context) (interface{}, error) {
 	return e.value, nil
 }
 
-func (e literal) String() string {
-	return fmt.Sprint("'", e.value, "'")
+func (e *Literal) String() string {
+	return Format(e)
+}
+
+// Call is function call, name of the function is always in upper case
+type Call struct {
+	commentSet
+	name string
+	args []Expr
+}
+
+// Name returns name of the called function
+func (e *Call) Name() string {
+	return e.name
 }
 
-type call struct {
-	ident *ident
-	args  []Expr
+// Args returns arguments of the call
+func (e *Call) Args() []Expr {
+	return e.args
 }
 
-func (e *call) Eval(context Context) (value interface{}, err error) {
+func (e *Call) Eval(context Context) (value interface{}, err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			if err, ok := r.(error); ok {
 				panic(err)
 			}
 			value = nil
-			err = errors.New(fmt.Sprint("error in call of ", e.ident.name, ": ", r))
+			err = errors.New(fmt.Sprint("error in call of ", e.name, ": ", r))
 		}
 	}()
-	var list []interface{}
-	for _, p := range e.args {
-		v, err := p.Eval(context)
+	args := make([]*Arg, len(e.args))
+	for i, p := range e.args {
+		args[i] = &Arg{expr: p, context: context}
+	}
+	for _, fn := range context.cast().lazyFunctions {
+		if v, err := fn(e.name, args); err == nil {
+			return validate(v, e.name, context)
+		} else if _, ok := err.(NOFUNC); !ok {
+			return nil, err
+		}
+	}
+	// lazy builtins are not passed to Functions, otherwise they would lose their laziness
+	if fn, ok := lazyBuiltins[e.name]; ok {
+		v, err := fn(args, context)
 		if err != nil {
 			return nil, err
 		}
-		list = append(list, v)
+		return validate(v, e.name, context)
+	}
+	list, err := EvalArgs(args)
+	if err != nil {
+		return nil, err
 	}
 	for _, fn := range context.cast().functions {
-		if v, err := fn(e.ident.name, list); err == nil {
-			return validate(v, e.ident.name)
+		if v, err := fn(e.name, list); err == nil {
+			return validate(v, e.name, context)
 		} else if _, ok := err.(NOFUNC); !ok {
 			return nil, err
 		}
 	}
-	v, err := builtin(e.ident.name, list, context)
+	if d, ok := context.cast().defined[e.name]; ok {
+		v, err := d.call(context.cast(), list)
+		if err != nil {
+			return nil, err
+		}
+		return validate(v, e.name, context)
+	}
+	v, err := builtin(e.name, list, context)
 	if err != nil {
 		return nil, err
 	}
-	return validate(v, e.ident.name)
+	return validate(v, e.name, context)
+}
+
+func (e *Call) String() string {
+	return Format(e)
+}
+
+// List is list of expressions in brackets, it evaluates to []interface{}
+type List struct {
+	commentSet
+	elems []Expr
+}
+
+// Elems returns elements of the list
+func (e *List) Elems() []Expr {
+	return e.elems
+}
+
+func (e *List) Eval(context Context) (interface{}, error) {
+	list := make([]interface{}, len(e.elems))
+	for i, x := range e.elems {
+		v, err := x.Eval(context)
+		if err != nil {
+			return nil, err
+		}
+		list[i] = v
+	}
+	return list, nil
+}
+
+func (e *List) String() string {
+	return Format(e)
+}
+
+// Lambda is anonymous function params -> body, it is allowed only as argument of
+// function call. Parameters shadow values of context in the body.
+type Lambda struct {
+	commentSet
+	params []string
+	body   Expr
+}
+
+// Params returns names of parameters
+func (e *Lambda) Params() []string {
+	return e.params
+}
+
+// Body returns body of the lambda
+func (e *Lambda) Body() Expr {
+	return e.body
+}
+
+// Eval fails, lambda is applied by functions taking it as argument, see Apply
+func (e *Lambda) Eval(context Context) (interface{}, error) {
+	return nil, errors.New("lambda can be only applied by function")
+}
+
+// Apply evaluates body of the lambda with parameters set to args
+func (e *Lambda) Apply(context Context, args ...interface{}) (interface{}, error) {
+	if len(args) != len(e.params) {
+		return nil, errors.New(fmt.Sprint("lambda expects ", len(e.params), " parameters, actual ", len(args)))
+	}
+	values := make(map[string]interface{}, len(args))
+	for i, p := range e.params {
+		values[p] = args[i]
+	}
+	return e.body.Eval(context.cast().with(values))
+}
+
+func (e *Lambda) String() string {
+	return Format(e)
+}
+
+// BadExpr is placeholder for source which could not be parsed, see ParseAll
+type BadExpr struct {
+	commentSet
+	from, to int // source range
 }
 
-func (e call) String() string {
-	return fmt.Sprint(e.ident, "(", e.args, ")")
+// From returns offset of the first byte of the source which could not be parsed
+func (e *BadExpr) From() int {
+	return e.from
 }
 
-type unary struct {
-	op token
+// To returns offset just after the source which could not be parsed
+func (e *BadExpr) To() int {
+	return e.to
+}
+
+func (e *BadExpr) Eval(context Context) (interface{}, error) {
+	return nil, errors.New(fmt.Sprint("syntax error at offset ", e.from))
+}
+
+func (e *BadExpr) String() string {
+	return Format(e)
+}
+
+// Unary is unary operation: +x, -x or !x
+type Unary struct {
+	commentSet
+	op Token
 	x  Expr
 }
 
-func (e *unary) Eval(context Context) (interface{}, error) {
+// Op returns operator, one of ADD, SUB or NOT
+func (e *Unary) Op() Token {
+	return e.op
+}
+
+// X returns operand
+func (e *Unary) X() Expr {
+	return e.x
+}
+
+func (e *Unary) Eval(context Context) (interface{}, error) {
 	v, err := e.x.Eval(context)
 	if err != nil {
 		return nil, err
@@ -138,33 +462,88 @@ func (e *unary) Eval(context Context) (interface{}, error) {
 			return nil, errors.New("not a number:" + fmt.Sprint(v))
 		}
 	}
-	return nil, errors.New("illegal unary operator" + string(e.op))
+	return nil, errors.New("illegal unary operator " + repr(e.op))
 }
 
-func (e unary) String() string {
-	return fmt.Sprint(" ( ", e.op, e.x, " ) ")
+func (e *Unary) String() string {
+	return Format(e)
 }
 
-type binary struct {
+// Binary is binary operation x op y
+type Binary struct {
+	commentSet
 	x  Expr
-	op token
+	op Token
 	y  Expr
 }
 
-func (e *binary) Eval(context Context) (interface{}, error) {
+// X returns left operand
+func (e *Binary) X() Expr {
+	return e.x
+}
+
+// Op returns operator
+func (e *Binary) Op() Token {
+	return e.op
+}
+
+// Y returns right operand
+func (e *Binary) Y() Expr {
+	return e.y
+}
+
+func (e *Binary) Eval(context Context) (interface{}, error) {
 	ix, err := e.x.Eval(context)
 	if err != nil {
 		return nil, err
 	}
+	// right side of ?? is evaluated only if left side is null
+	if e.op == COALESCE {
+		if ix != nil {
+			return ix, nil
+		}
+		return e.y.Eval(context)
+	}
+	// right side of && and || is not evaluated if left side decides the result
+	if b, ok := ix.(bool); ok && (e.op == AND && !b || e.op == OR && b) {
+		return b, nil
+	}
 	iy, err := e.y.Eval(context)
 	if err != nil {
 		return nil, err
 	}
+	if e.op == CONCAT {
+		return concat(ix, iy)
+	}
 	r, ok, _ := tryNils(ix, iy, e.op)
 	if ok {
 		return r, nil
 	}
+	if r, ok, err := tryDates(ix, iy, e.op, context.cast().localTimeZone); ok {
+		return r, err
+	}
 	switch e.op {
+	case IN, NOTIN:
+		return member(ix, iy, e.op == NOTIN, context.cast().localTimeZone)
+	case POW, MOD:
+		x, ok := ix.(*big.Rat)
+		if !ok {
+			return nil, errors.New("not a number:" + fmt.Sprint(ix))
+		}
+		y, ok := iy.(*big.Rat)
+		if !ok {
+			return nil, errors.New("not a number:" + fmt.Sprint(iy))
+		}
+		var r *big.Rat
+		if e.op == POW {
+			r, err = pow(x, y)
+		} else {
+			r, err = mod(x, y)
+		}
+		if _, ok := err.(DivisionByZeroError); ok {
+			return context.cast().divisionByZero()
+		}
+		return r, err
 	case ADD, LT, LTE, GT, GTE:
 		r, ok, _ := tryNumbers(ix, iy, e.op)
 		if ok {
@@ -176,6 +555,11 @@ func (e *binary) Eval(context Context) (interface{}, error) {
 		}
 		return nil, errors.New("not a string:" + fmt.Sprint(s))
 	case MUL, DIV, SUB:
+		if y, ok := iy.(*big.Rat); ok && e.op == DIV && y.Sign() == 0 {
+			if _, ok := ix.(*big.Rat); ok {
+				return context.cast().divisionByZero()
+			}
+		}
 		r, ok, s := tryNumbers(ix, iy, e.op)
 		if ok {
 			return r, nil
@@ -209,14 +593,343 @@ func (e *binary) Eval(context Context) (interface{}, error) {
 		return nil, errors.New("not a boolean:" + fmt.Sprint(s))
 	}
 	// TODO single equal sign not shown, error reporting should be fixed
-	return nil, errors.New("illegal binary operator" + string(e.op))
+	return nil, errors.New("illegal binary operator " + repr(e.op))
+}
+
+func (e *Binary) String() string {
+	return Format(e)
+}
+
+// Like is pattern matching x LIKE pattern ESCAPE escape, or ILIKE for case insensitive
+// matching. In the pattern % matches any sequence of characters and _ matches exactly
+// one character, escape character given by optional ESCAPE clause makes the following
+// character match itself, default escape character is backslash.
+type Like struct {
+	commentSet
+	x       Expr
+	op      Token
+	pattern Expr
+	escape  Expr           // nil if there is no ESCAPE clause
+	re      *regexp.Regexp // compiled pattern given as literal
+}
+
+// X returns matched operand
+func (e *Like) X() Expr {
+	return e.x
+}
+
+// Op returns operator, LIKE or ILIKE
+func (e *Like) Op() Token {
+	return e.op
+}
+
+// Pattern returns pattern
+func (e *Like) Pattern() Expr {
+	return e.pattern
+}
+
+// Escape returns escape character of ESCAPE clause or nil
+func (e *Like) Escape() Expr {
+	return e.escape
+}
+
+func (e *Like) Eval(context Context) (interface{}, error) {
+	ix, err := e.x.Eval(context)
+	if err != nil {
+		return nil, err
+	}
+	re := e.re
+	if re == nil {
+		ip, err := e.pattern.Eval(context)
+		if err != nil {
+			return nil, err
+		}
+		escape := '\\'
+		if e.escape != nil {
+			ie, err := e.escape.Eval(context)
+			if err != nil {
+				return nil, err
+			}
+			if r, ok, _ := tryNils(ip, ie, e.op); ok {
+				return r, nil
+			}
+			s, ok := ie.(string)
+			if !ok {
+				return nil, errors.New("not a string:" + fmt.Sprint(ie))
+			}
+			if escape, err = escapeRune(s); err != nil {
+				return nil, err
+			}
+		}
+		if r, ok, _ := tryNils(ix, ip, e.op); ok {
+			return r, nil
+		}
+		s, ok := ip.(string)
+		if !ok {
+			return nil, errors.New("not a string:" + fmt.Sprint(ip))
+		}
+		if re, err = likeRegexp(s, escape, e.op == ILIKE); err != nil {
+			return nil, err
+		}
+	}
+	if ix == nil {
+		return nil, nil
+	}
+	s, ok := ix.(string)
+	if !ok {
+		return nil, errors.New("not a string:" + fmt.Sprint(ix))
+	}
+	return re.MatchString(s), nil
 }
 
-func (e binary) String() string {
-	return fmt.Sprint(" ( ", e.x, e.op, e.y, " ) ")
+func (e *Like) String() string {
+	return Format(e)
 }
 
-func tryNils(ix, iy interface{}, op token) (interface{}, bool, interface{}) {
+// escapeRune returns escape character of LIKE pattern, -1 for empty string meaning that
+// pattern has no escape character
+func escapeRune(s string) (rune, error) {
+	if s == "" {
+		return -1, nil
+	}
+	r, n := utf8.DecodeRuneInString(s)
+	if n != len(s) {
+		return -1, errors.New("escape must be a single character: " + s)
+	}
+	return r, nil
+}
+
+// likeRegexp compiles LIKE pattern to regular expression matching whole strings
+func likeRegexp(pattern string, escape rune, fold bool) (*regexp.Regexp, error) {
+	var b strings.Builder
+	if fold {
+		b.WriteString("(?i)")
+	}
+	b.WriteString("(?s)^")
+	escaped := false
+	for _, r := range pattern {
+		switch {
+		case escaped:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+			escaped = false
+		case r == escape:
+			escaped = true
+		case r == '%':
+			b.WriteString(".*")
+		case r == '_':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	if escaped {
+		return nil, errors.New("pattern ends with escape character: " + pattern)
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+// Conditional is conditional operation cond ? then : else, only one of then and else
+// is evaluated
+type Conditional struct {
+	commentSet
+	cond, then, els Expr
+}
+
+// Cond returns condition
+func (e *Conditional) Cond() Expr {
+	return e.cond
+}
+
+// Then returns expression evaluated if condition is true
+func (e *Conditional) Then() Expr {
+	return e.then
+}
+
+// Else returns expression evaluated if condition is false
+func (e *Conditional) Else() Expr {
+	return e.els
+}
+
+func (e *Conditional) Eval(context Context) (interface{}, error) {
+	v, err := e.cond.Eval(context)
+	if err != nil {
+		return nil, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return nil, errors.New("not a boolean:" + fmt.Sprint(v))
+	}
+	if b {
+		return e.then.Eval(context)
+	}
+	return e.els.Eval(context)
+}
+
+func (e *Conditional) String() string {
+	return Format(e)
+}
+
+// concat concatenates strings, nil is taken for empty string
+func concat(ix, iy interface{}) (interface{}, error) {
+	var x, y string
+	if ix != nil {
+		s, ok := ix.(string)
+		if !ok {
+			return nil, errors.New("not a string:" + fmt.Sprint(ix))
+		}
+		x = s
+	}
+	if iy != nil {
+		s, ok := iy.(string)
+		if !ok {
+			return nil, errors.New("not a string:" + fmt.Sprint(iy))
+		}
+		y = s
+	}
+	return x + y, nil
+}
+
+// maxBits limits size in bits of numerator and denominator of results of exponentiation
+const maxBits = 1 << 18
+
+// pow raises x to the power of y. Integer powers are exact. Fractional powers are exact
+// if x is a perfect power, like 8 ^ (2/3) or 0.25 ^ 0.5, otherwise they are approximated
+// by the nearest float64. Negative numbers have no fractional powers. Results which
+// could exceed maxBits are errors.
+func pow(x, y *big.Rat) (*big.Rat, error) {
+	if !y.IsInt() {
+		if x.Sign() < 0 {
+			return nil, errors.New("fractional power of negative number: " + numberString(x) + " ^ " + numberString(y))
+		}
+		num, ok := root(x.Num(), y.Denom())
+		if ok {
+			var den *big.Int
+			den, ok = root(x.Denom(), y.Denom())
+			x = new(big.Rat).SetFrac(num, den)
+		}
+		if !ok {
+			fx, _ := x.Float64()
+			fy, _ := y.Float64()
+			return floatRat(math.Pow(fx, fy))
+		}
+		y = new(big.Rat).SetInt(y.Num())
+	}
+	n := new(big.Int).Abs(y.Num())
+	if x.Sign() != 0 && x.Num().CmpAbs(x.Denom()) != 0 { // powers of 0, 1 and -1 are small
+		// a ^ n has at most n times as many bits as a, it is checked before computing it
+		bits := x.Num().BitLen()
+		if d := x.Denom().BitLen(); d > bits {
+			bits = d
+		}
+		if !n.IsInt64() || n.Int64() > maxBits || int64(bits)*n.Int64() > maxBits {
+			return nil, errors.New("result too large: power with exponent " + n.String())
+		}
+	}
+	num := new(big.Int).Exp(x.Num(), n, nil)
+	den := new(big.Int).Exp(x.Denom(), n, nil)
+	if y.Sign() < 0 {
+		if x.Sign() == 0 {
+			return nil, DivisionByZeroError{}
+		}
+		num, den = den, num
+	}
+	return new(big.Rat).SetFrac(num, den), nil
+}
+
+// root returns k-th root of non-negative n and whether it is exact
+func root(n, k *big.Int) (*big.Int, bool) {
+	if n.Cmp(big.NewInt(1)) <= 0 {
+		return new(big.Int).Set(n), true
+	}
+	if k.Cmp(big.NewInt(int64(n.BitLen()))) >= 0 {
+		return big.NewInt(1), false // 1 < root < 2
+	}
+	// Newton's method starting above the root, it decreases until the floor of the root
+	km1 := new(big.Int).Sub(k, big.NewInt(1))
+	x := new(big.Int).Lsh(big.NewInt(1), uint(n.BitLen())/uint(k.Int64())+1)
+	for {
+		t := new(big.Int).Exp(x, km1, nil)
+		t.Quo(n, t)
+		t.Add(t, new(big.Int).Mul(km1, x))
+		t.Quo(t, k)
+		if t.Cmp(x) >= 0 {
+			break
+		}
+		x = t
+	}
+	return x, new(big.Int).Exp(x, k, nil).Cmp(n) == 0
+}
+
+// floatRat converts float to number by its shortest decimal representation
+func floatRat(f float64) (*big.Rat, error) {
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return nil, errors.New("result out of range: " + fmt.Sprint(f))
+	}
+	r, _ := shortestRat(f, 64)
+	return r, nil
+}
+
+// mod returns remainder of truncated division x / y, it has sign of x
+func mod(x, y *big.Rat) (*big.Rat, error) {
+	if y.Sign() == 0 {
+		return nil, DivisionByZeroError{}
+	}
+	q := new(big.Rat).Quo(x, y)
+	r := new(big.Int).Quo(q.Num(), q.Denom())
+	return new(big.Rat).Sub(x, new(big.Rat).Mul(y, q.SetInt(r))), nil
+}
+
+// member returns whether list contains x, or the opposite if not is true
+func member(x, list interface{}, not bool, location *time.Location) (interface{}, error) {
+	l, ok := list.([]interface{})
+	if !ok {
+		return nil, errors.New("not a list:" + fmt.Sprint(list))
+	}
+	for _, y := range l {
+		eq, err := equal(x, y, location)
+		if err != nil {
+			return nil, err
+		}
+		if eq {
+			return !not, nil
+		}
+	}
+	return not, nil
+}
+
+// equal compares numbers, strings, booleans and dates, nil is equal only to nil. Dates
+// are compared in the location, see compareDates.
+func equal(x, y interface{}, location *time.Location) (bool, error) {
+	if x == nil || y == nil {
+		return x == nil && y == nil, nil
+	}
+	switch a := x.(type) {
+	case *big.Rat:
+		if b, ok := y.(*big.Rat); ok {
+			return a.Cmp(b) == 0, nil
+		}
+		return false, errors.New("not a number:" + fmt.Sprint(y))
+	case string:
+		if b, ok := y.(string); ok {
+			return a == b, nil
+		}
+		return false, errors.New("not a string:" + fmt.Sprint(y))
+	case bool:
+		if b, ok := y.(bool); ok {
+			return a == b, nil
+		}
+		return false, errors.New("not a boolean:" + fmt.Sprint(y))
+	case time.Time:
+		if b, ok := y.(time.Time); ok {
+			return compareDates(a, b, location) == 0, nil
+		}
+		return false, errors.New("not a date:" + fmt.Sprint(y))
+	}
+	return false, errors.New("not comparable:" + fmt.Sprint(x))
+}
+
+func tryNils(ix, iy interface{}, op Token) (interface{}, bool, interface{}) {
 	if ix == nil || iy == nil {
 		switch op {
 		case EQ:
@@ -230,7 +943,7 @@ func tryNils(ix, iy interface{}, op token) (interface{}, bool, interface{}) {
 	return nil, false, nil
 }
 
-func tryNumbers(ix, iy interface{}, op token) (interface{}, bool, interface{}) {
+func tryNumbers(ix, iy interface{}, op Token) (interface{}, bool, interface{}) {
 	x, ok := ix.(*big.Rat)
 	if !ok {
 		return nil, false, nil
@@ -248,19 +961,154 @@ func tryNumbers(ix, iy interface{}, op token) (interface{}, bool, interface{}) {
 		return new(big.Rat).Mul(x, y), true, nil
 	case DIV:
 		return new(big.Rat).Quo(x, y), true, nil
+	case EQ, NEQ, LT, LTE, GT, GTE:
+		return compared(x.Cmp(y), op), true, nil
+	}
+	return nil, false, nil
+}
+
+// compared returns result of comparison operator given result c of comparing operands:
+// -1 if x < y, 0 if x == y or 1 if x > y
+func compared(c int, op Token) bool {
+	switch op {
 	case EQ:
-		return x.Cmp(y) == 0, true, nil
+		return c == 0
 	case NEQ:
-		return x.Cmp(y) != 0, true, nil
-	case LT, GTE:
-		return x.Cmp(y) == -1, true, nil
-	case GT, LTE:
-		return x.Cmp(y) == 1, true, nil
+		return c != 0
+	case LT:
+		return c < 0
+	case LTE:
+		return c <= 0
+	case GT:
+		return c > 0
+	case GTE:
+		return c >= 0
+	}
+	return false
+}
+
+// maxDays limits number of days added to dates
+const maxDays = 1 << 24
+
+// tryDates compares dates, adds number of days to a date or subtracts it, difference of
+// two dates is number of days. Days are added to dates, see IsDate, as calendar days and
+// fractions of days are ignored. Days are added to datetimes in the location keeping time
+// of day, fractions are added as hours, minutes and seconds. Differences are calculated
+// from dates and times of day in the location so that days are not shortened or
+// lengthened by daylight saving time changes. See compareDates for comparisons.
+func tryDates(ix, iy interface{}, op Token, location *time.Location) (interface{}, bool, error) {
+	x, xok := ix.(time.Time)
+	y, yok := iy.(time.Time)
+	switch op {
+	case EQ, NEQ, LT, LTE, GT, GTE:
+		switch {
+		case xok && yok:
+			return compared(compareDates(x, y, location), op), true, nil
+		case xok:
+			return nil, true, errors.New("not a date:" + fmt.Sprint(iy))
+		case yok:
+			return nil, true, errors.New("not a date:" + fmt.Sprint(ix))
+		}
+		return nil, false, nil
+	case ADD, SUB:
+	default:
+		return nil, false, nil
+	}
+	switch {
+	case xok && yok:
+		if op == SUB {
+			return daysBetween(y, x, location), true, nil
+		}
+		return nil, true, errors.New("dates can not be added: " + fmt.Sprint(ix, " + ", iy))
+	case xok:
+		n, ok := iy.(*big.Rat)
+		if !ok {
+			return nil, true, errors.New("not a number:" + fmt.Sprint(iy))
+		}
+		if op == SUB {
+			n = new(big.Rat).Neg(n)
+		}
+		return addDays(x, n, location)
+	case yok:
+		n, ok := ix.(*big.Rat)
+		if !ok {
+			return nil, true, errors.New("not a number:" + fmt.Sprint(ix))
+		}
+		if op == SUB {
+			return nil, true, errors.New("date can not be subtracted from number: " + fmt.Sprint(ix, " - ", iy))
+		}
+		return addDays(y, n, location)
 	}
 	return nil, false, nil
 }
 
-func tryStrings(ix, iy interface{}, op token) (interface{}, bool, interface{}) {
+// compareDates compares dates and datetimes by these rules:
+//   - two datetimes are compared as instants, their time zones do not matter, so
+//     12:00 UTC equals 07:00 -0500
+//   - two dates are compared by calendar day
+//   - date and datetime are compared by calendar day of the datetime in location, the
+//     time zone of the context, so 2021-03-02T03:00:00Z equals date 2021-03-01 in New
+//     York and date 2021-03-02 in UTC; datetime at midnight is not treated as date
+func compareDates(x, y time.Time, location *time.Location) int {
+	dx, dy := IsDate(x), IsDate(y)
+	if !dx && !dy {
+		return x.Compare(y)
+	}
+	x, y = wallClock(x, location), wallClock(y, location)
+	x = time.Date(x.Year(), x.Month(), x.Day(), 0, 0, 0, 0, time.UTC)
+	y = time.Date(y.Year(), y.Month(), y.Day(), 0, 0, 0, 0, time.UTC)
+	return x.Compare(y)
+}
+
+// dateLocation marks dates: date is time.Time at midnight in this location, it has no
+// time of day and no time zone. Any other time.Time is datetime, that is an instant.
+var dateLocation = time.FixedZone("DATE", 0)
+
+// Date returns date, Values and Functions give dates made by it and datetimes as any
+// other time.Time
+func Date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, dateLocation)
+}
+
+// IsDate returns whether t is date made by Date or by DATE, DATEVALUE or TODAY
+func IsDate(t time.Time) bool {
+	return t.Location() == dateLocation
+}
+
+func addDays(t time.Time, n *big.Rat, location *time.Location) (interface{}, bool, error) {
+	days := new(big.Int).Quo(n.Num(), n.Denom())
+	if !days.IsInt64() || days.Int64() > maxDays || days.Int64() < -maxDays {
+		return nil, true, errors.New("number of days out of range: " + numberString(n))
+	}
+	if IsDate(t) {
+		return t.AddDate(0, 0, int(days.Int64())), true, nil
+	}
+	// fraction of day in nanoseconds
+	f := new(big.Rat).Sub(n, new(big.Rat).SetInt(days))
+	f.Mul(f, new(big.Rat).SetInt64(int64(24*time.Hour)))
+	ns := new(big.Int).Quo(f.Num(), f.Denom())
+	return t.In(location).AddDate(0, 0, int(days.Int64())).Add(time.Duration(ns.Int64())), true, nil
+}
+
+// daysBetween returns number of days from x to y, see tryDates
+func daysBetween(x, y time.Time, location *time.Location) *big.Rat {
+	x, y = wallClock(x, location), wallClock(y, location)
+	ns := big.NewInt(y.Unix() - x.Unix())
+	ns.Mul(ns, big.NewInt(int64(time.Second)))
+	ns.Add(ns, big.NewInt(int64(y.Nanosecond()-x.Nanosecond())))
+	return new(big.Rat).SetFrac(ns, big.NewInt(int64(24*time.Hour)))
+}
+
+// wallClock returns date and time of day of datetime in the location, or date as it is,
+// in UTC
+func wallClock(t time.Time, location *time.Location) time.Time {
+	if !IsDate(t) {
+		t = t.In(location)
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+}
+
+func tryStrings(ix, iy interface{}, op Token) (interface{}, bool, interface{}) {
 	x, ok := ix.(string)
 	if !ok && ix != nil {
 		return nil, false, ix
@@ -288,7 +1136,7 @@ func tryStrings(ix, iy interface{}, op token) (interface{}, bool, interface{}) {
 	return nil, false, nil
 }
 
-func tryBools(ix, iy interface{}, op token) (interface{}, bool, interface{}) {
+func tryBools(ix, iy interface{}, op Token) (interface{}, bool, interface{}) {
 	x, ok := ix.(bool)
 	if !ok {
 		return nil, false, nil
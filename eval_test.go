@@ -1,7 +1,12 @@
 package eval
 
 import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"math"
 	"math/big"
+	"reflect"
 	"strconv"
 	"strings"
 	"testing"
@@ -27,6 +32,23 @@ func test_functions(name string, args []interface{}) (interface{}, error) {
 
 var test_context = NewContext().AddValues(test_values).AddFunctions(test_functions).SetTimeZone(time.FixedZone("MY", 0))
 
+func TestDottedFunctionNames(t *testing.T) {
+	functions := func(name string, args []interface{}) (interface{}, error) {
+		if name == "MATH.TWICE" && len(args) == 1 {
+			return new(big.Rat).Mul(args[0].(*big.Rat), big.NewRat(2, 1)), nil
+		}
+		return nil, NOFUNC{}
+	}
+	e, err := ParseString("math.twice(3) + Math.Twice(1)")
+	if err != nil {
+		t.Fatal("failed to parse:", err)
+	}
+	v, err := e.Eval(NewContext().AddFunctions(functions))
+	if err != nil || v.(*big.Rat).Cmp(big.NewRat(8, 1)) != 0 {
+		t.Error("failed to call function with dotted name, actual:", v, err)
+	}
+}
+
 func TestParsing(t *testing.T) {
 	{
 		se := "2+2)  *2-1"
@@ -38,6 +60,47 @@ func TestParsing(t *testing.T) {
 			t.Error("parsing of missing opening parenthesis failed, expression returned:", se)
 		}
 	}
+	{
+		se := "left(name,\n\tmid('Вот', 1, 2)"
+		_, err := ParseString(se)
+		var pe *ParseError
+		if !errors.As(err, &pe) {
+			t.Fatal("parse error expected:", se, err)
+		}
+		if pe.Line != 2 || pe.Column != 18 || pe.Offset != 31 || pe.Length != 0 {
+			t.Error("wrong error position:", pe.Line, pe.Column, pe.Offset, pe.Length)
+		}
+		if len(pe.Expected) != 2 || pe.Expected[0] != "," || pe.Expected[1] != ")" {
+			t.Error("wrong expected tokens:", pe.Expected)
+		}
+		if s := pe.Snippet(); s != "\tmid('Вот', 1, 2)\n\t                ^" {
+			t.Error("wrong snippet:\n" + s)
+		}
+	}
+}
+
+func TestStringLiterals(t *testing.T) {
+	mustResult(t, `'it\'s'`, "it's")
+	mustResult(t, `"say \"hi\""`, `say "hi"`)
+	mustResult(t, `'a\\b\tc\nd\u00E9'`, "a\\b\tc\ndé")
+	mustResult(t, `len('\u0412\u043e\u0442')`, big.NewRat(3, 1))
+	tests := []struct {
+		src     string
+		message string
+		column  int
+	}{
+		{"'abc", "unterminated string", 1},
+		{"1 + \"abc\\\"", "unterminated string", 5},
+		{"{abc + 1", "unterminated identifier", 1},
+		{"'a\\qb'", "unknown escape sequence", 3},
+		{"'\\u12x4'", "illegal unicode escape sequence", 2},
+	}
+	for _, test := range tests {
+		_, err := ParseString(test.src)
+		if pe, ok := err.(*ParseError); !ok || pe.Message != test.message || pe.Column != test.column {
+			t.Error("wrong error for:", test.src, "expected:", test.message, test.column, "actual:", err)
+		}
+	}
 }
 
 func TestStringFunctions(t *testing.T) {
@@ -176,21 +239,28 @@ func TestStringFunctions(t *testing.T) {
 
 	mustErrorEvaluating(t, "datevalue()", "function datevalue: failed to check number of parameters, no parameters")
 	mustErrorEvaluating(t, "datevalue(0)", "function datevalue: failed to check type of parameters, number parameter")
-	mustResult(t, "datevalue('2001-01-02')", time.Date(2001, 01, 02, 0, 0, 0, 0, test_context.(*context).localTimeZone))
+	mustResult(t, "datevalue('2001-01-02')", Date(2001, 01, 02))
 
 }
 
 func mustErrorEvaluating(t *testing.T, expression string, message ...string) {
+	t.Helper()
+	mustErrorIn(t, test_context, Standard, expression, message...)
+}
+
+// mustErrorIn evaluates expression written in dialect in context, evaluation must fail
+func mustErrorIn(t *testing.T, context Context, dialect Dialect, expression string, message ...string) {
+	t.Helper()
 	msg := ""
 	if len(message) > 0 {
 		msg = " should be:" + strings.Join(message, ", ")
 	}
-	e, err := ParseString(expression)
+	e, err := dialect.ParseString(expression)
 	if err != nil {
 		t.Error("failed to parse:", expression, err)
 		return
 	}
-	v, err := e.Eval(test_context)
+	v, err := e.Eval(context)
 	if err == nil {
 		t.Error("no error returned on evaluate:", expression, msg, " instead value returned:", v)
 		return
@@ -198,22 +268,960 @@ func mustErrorEvaluating(t *testing.T, expression string, message ...string) {
 }
 
 func mustResult(t *testing.T, expression string, value interface{}) {
-	e, err := ParseString(expression)
+	t.Helper()
+	mustResultIn(t, test_context, Standard, expression, value)
+}
+
+// mustResultIn evaluates expression written in dialect in context
+func mustResultIn(t *testing.T, context Context, dialect Dialect, expression string, value interface{}) {
+	t.Helper()
+	e, err := dialect.ParseString(expression)
 	if err != nil {
 		t.Error("failed to parse:", expression, " error at parsing: ", err)
 		return
 	}
-	v, err := e.Eval(test_context)
+	v, err := e.Eval(context)
+	checkResult(t, expression, v, err, value)
+}
+
+// checkResult reports failed evaluation of src which returned v and err instead of value
+func checkResult(t *testing.T, src string, v interface{}, err error, value interface{}) {
+	t.Helper()
 	if err != nil {
-		t.Error("failed to evaluate:", expression, " error at evaluation: ", err)
+		t.Error("failed to evaluate:", src, " error at evaluation: ", err)
 		return
 	}
-	if r, ok := v.(*big.Rat); ok {
-		if r.Cmp(value.(*big.Rat)) != 0 {
-			t.Error("failed to evaluate:", expression, " expected:", value, " actual:", v)
+	if !sameValue(v, value) {
+		t.Error("failed to evaluate:", src, " expected:", value, " actual:", v)
+	}
+}
+
+// sameValue returns whether v equals value, numbers are compared by value, dates and
+// datetimes as instants, lists by elements
+func sameValue(v, value interface{}) bool {
+	switch x := v.(type) {
+	case *big.Rat:
+		r, ok := value.(*big.Rat)
+		return ok && x.Cmp(r) == 0
+	case time.Time:
+		d, ok := value.(time.Time)
+		return ok && x.Equal(d) && IsDate(x) == IsDate(d)
+	case []interface{}:
+		l, ok := value.([]interface{})
+		if !ok || len(l) != len(x) {
+			return false
 		}
-	} else if v != value {
-		t.Error("failed to evaluate:", expression, " expected:", value, " actual:", v)
-		return
+		for i := range x {
+			if !sameValue(x[i], l[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(v, value)
+}
+
+func TestSalesforce(t *testing.T) {
+	tests := []struct {
+		src   string
+		value interface{}
+	}{
+		{"IF(number_1 = 1, 'one', 'other')", "one"},
+		{"string_a & ' and ' & string_b", "a string and b string"},
+		{"'x' & NULL & 'y'", "xy"},
+		{"2 ^ 10", big.NewRat(1024, 1)},
+		{"-2 ^ 2", big.NewRat(-4, 1)},
+		{"(2/3) ^ -2", big.NewRat(9, 4)},
+		{"AND(number_1 = 1, NOT(string_a <> 'a string'))", true},
+		{"OR(FALSE, number_1 > 1)", false},
+	}
+	for _, test := range tests {
+		mustResultIn(t, test_context, Salesforce, test.src, test.value)
+	}
+	for _, src := range []string{"(-8) ^ (1/3)", "0 ^ -1", "1 & 2"} {
+		mustErrorIn(t, test_context, Salesforce, src)
+	}
+	e, _ := Salesforce.ParseString("IF(a = b, x <> y, c & d)")
+	if s := (&Printer{Dialect: Salesforce}).Format(e); s != "IF(a = b, x <> y, c & d)" {
+		t.Error("wrong Salesforce format:", s)
+	}
+}
+
+func TestLazyEvaluation(t *testing.T) {
+	calls := 0
+	counting := func(name string, args []interface{}) (interface{}, error) {
+		if name == "COUNT" {
+			calls++
+			return big.NewRat(int64(calls), 1), nil
+		}
+		return nil, NOFUNC{}
+	}
+	unless := func(name string, args []*Arg) (interface{}, error) {
+		if name != "UNLESS" {
+			return nil, NOFUNC{}
+		}
+		NumOfArgs(args, 2)
+		v, err := args[0].Value()
+		if err != nil || v == true {
+			return nil, err
+		}
+		return args[1].Value()
+	}
+	context := NewContext().AddValues(test_values).AddFunctions(counting).AddLazyFunctions(unless)
+	tests := []struct {
+		src   string
+		value interface{}
+	}{
+		{"IF(number_1 == 1, 'one', unknown + count())", "one"},
+		{"IF(number_1 != 1, count(), 'other')", "other"},
+		{"CASE(number_1, 2, count(), 1, 'one', count())", "one"},
+		{"CASE(string_a, 'x', count(), 'y', count(), 'default')", "default"},
+		{"NULLVALUE(string_a, count())", "a string"},
+		{"BLANKVALUE('', 'blank')", "blank"},
+		{"AND(false, count() > 0)", false},
+		{"OR(true, unknown)", true},
+		{"number_1 != 1 && count() > 0", false},
+		{"number_1 == 1 || unknown", true},
+		{"UNLESS(number_1 == 1, count())", nil},
+		{"UNLESS(number_1 != 1, 'done')", "done"},
+		{"number_1 == 1 ? 'one' : count()", "one"},
+		{"string_a ?? count()", "a string"},
+		{"null ?? null ?? string_a ?? unknown", "a string"},
+		{"number_1 != 1 ? count() : number_1 > 0 ? 'positive' : count()", "positive"},
+	}
+	for _, test := range tests {
+		mustResultIn(t, context, Standard, test.src, test.value)
+	}
+	if calls != 0 {
+		t.Error("arguments evaluated although not needed:", calls)
+	}
+	mustResultIn(t, context, Standard, "IF(count() > 0, count(), 0) + NULLVALUE(count(), 0)", big.NewRat(5, 1))
+	for _, src := range []string{"IF(1, 2, 3)", "AND(true, 1)", "IF(true, 1)", "number_1 == 1 && 2", "1 ? 2 : 3", "null ? 2 : 3"} {
+		mustErrorIn(t, context, Standard, src)
+	}
+	// lazy builtins are overridden by LazyFunctions, Functions are not asked for them
+	eager := func(name string, args []interface{}) (interface{}, error) {
+		if args[0] == nil || name != "IF" && name != "NULLVALUE" {
+			return nil, NOFUNC{}
+		}
+		return "eager", nil
+	}
+	custom := func(name string, args []*Arg) (interface{}, error) {
+		if name != "IF" {
+			return nil, NOFUNC{}
+		}
+		NumOfArgs(args, 3)
+		return args[2].Value()
+	}
+	context = NewContext().AddValues(test_values).AddFunctions(counting).AddFunctions(eager).AddLazyFunctions(custom)
+	calls = 0
+	overrides := []struct {
+		src   string
+		value interface{}
+	}{
+		{"IF(number_1 == 1, 'one', 'other')", "other"},
+		{"IF(true, 1, 'custom') + NULLVALUE(null, 'b')", "customb"},
+		{"NULLVALUE(string_a, 1 / 0)", "a string"},
+		{"CASE(number_1, 1, 'one', count())", "one"},
+		{"MAP([1, 2], x -> x * 2)[1]", big.NewRat(4, 1)},
+		{"LET(a, 1, a + 1)", big.NewRat(2, 1)},
+	}
+	for _, test := range overrides {
+		mustResultIn(t, context, Standard, test.src, test.value)
+	}
+	if calls != 0 {
+		t.Error("arguments of lazy builtin evaluated:", calls)
+	}
+}
+
+func TestDivisionByZero(t *testing.T) {
+	tests := []string{"1 / 0", "number_1 / (number_1 - 1)", "MOD(5, 0)", "MOD(0, 0)", "5 % 0", "0 ^ -1"}
+	for _, src := range tests {
+		e, err := ParseString(src)
+		if err != nil {
+			t.Error("failed to parse:", src, err)
+			continue
+		}
+		if v, err := e.Eval(NewContext().AddValues(test_values)); !errors.As(err, &DivisionByZeroError{}) {
+			t.Error("division by zero expected:", src, v, err)
+		}
+		if v, err := e.Eval(NewContext().AddValues(test_values).SetZeroDivision(ZeroDivisionNull, nil)); v != nil || err != nil {
+			t.Error("null expected:", src, v, err)
+		}
+		context := NewContext().AddValues(test_values).SetZeroDivision(ZeroDivisionDefault, big.NewRat(-1, 1))
+		if v, err := e.Eval(context); err != nil || v.(*big.Rat).Cmp(big.NewRat(-1, 1)) != 0 {
+			t.Error("default value expected:", src, v, err)
+		}
+	}
+	context := NewContext().SetZeroDivision(ZeroDivisionDefault, big.NewRat(0, 1))
+	e, _ := Salesforce.ParseString("0 ^ -1 + 1 / 0 + 'a' / 0")
+	if v, err := e.Eval(context); err == nil || errors.As(err, &DivisionByZeroError{}) {
+		t.Error("string divided by zero should fail as not a number:", v, err)
+	}
+	mustResultIn(t, context, Standard, "MOD(7, 0) + 2", big.NewRat(2, 1))
+}
+
+func TestPowerAndModulo(t *testing.T) {
+	tests := []struct {
+		src   string
+		value *big.Rat
+	}{
+		{"2 ^ 3 ^ 2", big.NewRat(512, 1)},
+		{"2 ** 10", big.NewRat(1024, 1)},
+		{"-2 ^ 2", big.NewRat(-4, 1)},
+		{"2 ^ -2", big.NewRat(1, 4)},
+		{"8 ^ (2/3)", big.NewRat(4, 1)},
+		{"0.25 ^ 0.5", big.NewRat(1, 2)},
+		{"(27/8) ^ (-1/3)", big.NewRat(2, 3)},
+		{"0 ^ 0.5", big.NewRat(0, 1)},
+		{"2 ^ 0.5", big.NewRat(14142135623730951, 10000000000000000)},
+		{"7 % 3", big.NewRat(1, 1)},
+		{"-7 % 3", big.NewRat(-1, 1)},
+		{"7.5 % -2", big.NewRat(3, 2)},
+		{"2 * 7 % 4", big.NewRat(2, 1)},
+		{"MOD(-7, 3) == -7 % 3 ? 1 : 0", big.NewRat(1, 1)},
+		{"(2 ** 60000) / 2 ** 59999", big.NewRat(2, 1)},
+		{"1 ^ 1000000000 + (-1) ^ 1000000001 + 0 ^ 1000000000", big.NewRat(0, 1)},
+	}
+	for _, test := range tests {
+		mustResultIn(t, test_context, Standard, test.src, test.value)
+	}
+	for _, src := range []string{"(-8) ^ (1/3)", "'a' % 2", "2 % null + 'x' ^ 2", "10 ^ 100000", "(2 ** 60000) ** 60000 > 0", "0.5 ^ -300000", "(2 ** 200000) ^ 1.5"} {
+		mustErrorIn(t, test_context, Standard, src)
+	}
+}
+
+func TestMembership(t *testing.T) {
+	tests := []struct {
+		src   string
+		value interface{}
+	}{
+		{"string_a in ['x', 'a string']", true},
+		{"string_a not in ['x', 'a string']", false},
+		{"number_1 IN [0, 2 - 1]", true},
+		{"number_1 + 1 NOT IN [0, 1]", true},
+		{"true in [false]", false},
+		{"DATE(2020, 1, 2) in [DATE(2020, 1, 1), DATEVALUE('2020-01-02')]", true},
+		{"'a' in []", false},
+		{"null in [null]", nil},
+		{"null not in ['a']", nil},
+		{"'a' in null", nil},
+		{"'a' in ['b', null]", false},
+		{"'a' not in [null]", true},
+	}
+	for _, test := range tests {
+		mustResultIn(t, test_context, Standard, test.src, test.value)
+	}
+	e, _ := ParseString("[string_a, number_1 * 2, null]")
+	if v, err := e.Eval(test_context); err != nil || Format(&Literal{value: v}) != "['a string', 2, NULL]" {
+		t.Error("failed to evaluate list:", v, err)
+	}
+	for _, src := range []string{"1 in ['1']", "'a' in 'a'", "true in [1]", "[1] in [[1]]"} {
+		mustErrorIn(t, test_context, Standard, src)
+	}
+}
+
+func TestLike(t *testing.T) {
+	tests := []struct {
+		src   string
+		value interface{}
+	}{
+		{"'ACME Corp' LIKE 'ACME%'", true},
+		{"'acme corp' LIKE 'ACME%'", false},
+		{"'acme corp' ILIKE 'ACME%'", true},
+		{"'ACME' LIKE 'ACME%'", true},
+		{"'ACME' LIKE 'AC_E'", true},
+		{"'ACE' LIKE 'AC_E'", false},
+		{"'a.b' LIKE 'a_b' && 'a+b' like 'a+b' && 'a(b' LIKE 'a(%'", true},
+		{"'Ärger über' LIKE '_rger _ber'", true},
+		{"'ÄRGER' ILIKE 'ärger'", true},
+		{"'日本語' LIKE '_本_'", true},
+		{"'line\\nbreak' LIKE 'line%'", true},
+		{"'100%' LIKE '100\\\\%'", true},
+		{"'1000' LIKE '100\\\\%'", false},
+		{"'a_b' LIKE 'a#_b' ESCAPE '#' && 'axb' NOT IN ['a_b']", true},
+		{"'axb' LIKE 'a#_b' ESCAPE '#'", false},
+		{"'a\\\\b' LIKE 'a\\\\b' ESCAPE ''", true},
+		{"string_a LIKE '%' + 'string'", true},
+		{"string_a LIKE string_b", false},
+		{"null LIKE 'a'", nil},
+		{"string_a LIKE null", nil},
+		{"string_a LIKE '%' ESCAPE null", nil},
+	}
+	for _, test := range tests {
+		mustResultIn(t, test_context, Standard, test.src, test.value)
+	}
+	if e, _ := ParseString("a LIKE 'x%'"); e.(*Like).re == nil {
+		t.Error("literal pattern not compiled")
+	}
+	for _, src := range []string{"1 LIKE '1'", "'1' LIKE 1", "'a' LIKE string_a + '!' ESCAPE '!'", "'a' LIKE 'a' ESCAPE string_a"} {
+		mustErrorIn(t, test_context, Standard, src)
+	}
+}
+
+func TestDateArithmetic(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("time zone not available:", err)
+	}
+	context := NewContext().SetTimeZone(ny)
+	date := func(y, m, d int) time.Time {
+		return Date(y, time.Month(m), d)
+	}
+	datetime := func(y, m, d, h, min int) time.Time {
+		return time.Date(y, time.Month(m), d, h, min, 0, 0, ny)
+	}
+	tests := []struct {
+		src   string
+		value interface{}
+	}{
+		{"DATEVALUE('2015-01-01') + 30", date(2015, 1, 31)},
+		{"30 + DATEVALUE('2015-01-01')", date(2015, 1, 31)},
+		{"DATEVALUE('2015-03-01') - 1", date(2015, 2, 28)},
+		{"DATEVALUE('2015-01-01') + 1.9", date(2015, 1, 2)},
+		{"DATE(2021, 3, 13) + 1", date(2021, 3, 14)},
+		{"DATEVALUE('2016-03-01') - DATEVALUE('2016-02-01')", big.NewRat(29, 1)},
+		{"DATE(2021, 3, 1) - DATE(2021, 4, 1)", big.NewRat(-31, 1)},
+		{"DATE(2021, 3, 15) - DATE(2021, 3, 13)", big.NewRat(2, 1)},
+		{"DATETIMEVALUE('2021-03-13T17:00:00Z') + 1", datetime(2021, 3, 14, 12, 0)},
+		{"DATETIMEVALUE('2021-03-13T17:00:00Z') + 1.5", datetime(2021, 3, 15, 0, 0)},
+		{"DATETIMEVALUE('2021-03-14T12:00:00-0400') - 0.25", datetime(2021, 3, 14, 6, 0)},
+		{"DATETIMEVALUE('2021-03-14T16:00:00Z') - DATETIMEVALUE('2021-03-13T17:00:00Z')", big.NewRat(1, 1)},
+		{"DATETIMEVALUE('2021-03-14T05:00:00Z') - DATETIMEVALUE('2021-03-13T17:00:00Z')", big.NewRat(1, 2)},
+		{"DATETIMEVALUE('2021-03-14T16:00:00Z') - DATE(2021, 3, 14)", big.NewRat(1, 2)},
+		{"DATE(2021, 3, 14) - DATETIMEVALUE('2021-03-13T23:00:00Z')", big.NewRat(1, 4)},
+		{"DATEVALUE('2015-01-01') + null", nil},
+		// datetime at midnight is not a date
+		{"DATETIMEVALUE('2021-03-13T00:00:00Z') + 0.5", datetime(2021, 3, 13, 7, 0)},
+		{"DATETIMEVALUE('2021-03-13T05:00:00Z') + 1", datetime(2021, 3, 14, 0, 0)},
+		{"(DATETIMEVALUE('2021-03-01T00:00:00Z') + 0.5) + 0.5 == DATETIMEVALUE('2021-03-01T00:00:00Z') + 1", true},
+		{"DATETIMEVALUE('2021-03-01T00:00:00Z') - DATE(2021, 3, 1)", big.NewRat(-5, 24)},
+	}
+	for _, test := range tests {
+		mustResultIn(t, context, Standard, test.src, test.value)
+	}
+	for _, src := range []string{"DATE(2021, 1, 1) + DATE(2021, 1, 1)", "1 - DATE(2021, 1, 1)", "DATE(2021, 1, 1) + 'a'", "DATE(2021, 1, 1) * 2", "DATE(2021, 1, 1) + 1e10"} {
+		mustErrorIn(t, context, Standard, src)
+	}
+	e, _ := ParseString("TODAY()")
+	if v, err := e.Eval(context); err != nil || !IsDate(v.(time.Time)) || v.(time.Time).Format("2006-01-02") != time.Now().In(ny).Format("2006-01-02") {
+		t.Error("today is not a date:", v, err)
+	}
+}
+
+func TestDateComparisons(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("time zone not available:", err)
+	}
+	context := NewContext().SetTimeZone(ny)
+	tests := []struct {
+		src   string
+		value interface{}
+	}{
+		{"DATE(2021, 3, 1) < DATE(2021, 3, 2)", true},
+		{"DATE(2021, 3, 1) <= DATE(2021, 3, 1)", true},
+		{"DATE(2021, 3, 1) >= DATE(2021, 3, 1)", true},
+		{"DATE(2021, 3, 1) > DATE(2021, 3, 1)", false},
+		{"DATE(2021, 3, 1) == DATEVALUE('2021-03-01')", true},
+		{"DATE(2021, 3, 1) != DATE(2021, 3, 2)", true},
+		{"CloseDate < TODAY()", true},
+		{"TODAY() == NOW()", true},
+		{"NOW() > TODAY() - 1 && NOW() < TODAY() + 1", true},
+		// datetimes are compared as instants
+		{"DATETIMEVALUE('2021-03-01T12:00:00Z') == DATETIMEVALUE('2021-03-01T07:00:00-0500')", true},
+		{"DATETIMEVALUE('2021-03-01T12:00:00Z') < DATETIMEVALUE('2021-03-01T08:00:00-0500')", true},
+		// datetimes at midnight are not dates, they are in the previous day in New York
+		{"DATETIMEVALUE('2021-03-01T00:00:00Z') == DATE(2021, 3, 1)", false},
+		{"DATETIMEVALUE('2021-03-01T00:00:00+1400') == DATE(2021, 3, 1)", false},
+		{"DATETIMEVALUE('2021-03-01T00:00:00+1400') == DATE(2021, 2, 28)", true},
+		// date with datetime by calendar day of datetime in context time zone
+		{"DATETIMEVALUE('2021-03-02T03:00:00Z') == DATE(2021, 3, 1)", true},
+		{"DATE(2021, 3, 1) < DATETIMEVALUE('2021-03-02T05:00:00Z')", true},
+		{"DATE(2021, 3, 1) >= DATETIMEVALUE('2021-03-01T23:59:00-0500')", true},
+		{"DATE(2021, 3, 1) in [DATETIMEVALUE('2021-03-01T20:00:00-0500')]", true},
+		{"DATE(2021, 3, 1) < null", nil},
+	}
+	values := func(name string) (interface{}, bool) {
+		if name == "CloseDate" {
+			return time.Date(2020, 12, 31, 0, 0, 0, 0, time.UTC), true
+		}
+		return nil, false
+	}
+	context.AddValues(values)
+	for _, test := range tests {
+		mustResultIn(t, context, Standard, test.src, test.value)
+	}
+	// the same comparisons of date with datetime in other time zones of the context
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Skip("time zone not available:", err)
+	}
+	zones := []struct {
+		location *time.Location
+		src      string
+		value    interface{}
+	}{
+		{time.UTC, "DATETIMEVALUE('2021-03-02T03:00:00Z') == DATE(2021, 3, 1)", false},
+		{time.UTC, "DATETIMEVALUE('2021-03-02T03:00:00Z') == DATE(2021, 3, 2)", true},
+		{time.UTC, "DATETIMEVALUE('2021-03-01T00:00:00Z') == DATE(2021, 3, 1)", true},
+		{time.UTC, "DATETIMEVALUE('2021-03-01T00:00:00+1400') == DATE(2021, 2, 28)", true},
+		{tokyo, "DATETIMEVALUE('2021-03-01T20:00:00Z') == DATE(2021, 3, 2)", true},
+		{tokyo, "DATETIMEVALUE('2021-03-01T00:00:00+1400') == DATE(2021, 2, 28)", true},
+		{tokyo, "DATE(2021, 3, 1) < DATETIMEVALUE('2021-03-01T15:00:00Z')", true},
+		{tokyo, "DATETIMEVALUE('2021-03-01T12:00:00Z') == DATETIMEVALUE('2021-03-01T21:00:00+0900')", true},
+		{tokyo, "DATE(2021, 3, 1) == DATEVALUE('2021-03-01')", true},
+	}
+	for _, test := range zones {
+		mustResultIn(t, NewContext().SetTimeZone(test.location), Standard, test.src, test.value)
+	}
+	for _, src := range []string{"DATE(2021, 1, 1) == '2021-01-01'", "1 < DATE(2021, 1, 1)", "DATE(2021, 1, 1) && true"} {
+		mustErrorIn(t, context, Standard, src)
+	}
+}
+
+func TestNumberComparisons(t *testing.T) {
+	mustResult(t, "2 >= 2", true)
+	mustResult(t, "3 >= 2", true)
+	mustResult(t, "1 >= 2", false)
+	mustResult(t, "2 <= 2", true)
+	mustResult(t, "3 <= 2", false)
+	mustResult(t, "1 < 2", true)
+	mustResult(t, "2 > 2", false)
+}
+
+type testOwner struct {
+	FullName string `json:"name"`
+	Email    string `eval:"mail" json:"email"`
+	Age      int
+	Manager  *testOwner
+}
+
+type testAccount struct {
+	Id      string
+	Revenue float64
+	Owner   *testOwner
+	Tags    []string
+	Extra   map[string]interface{}
+	secret  string
+}
+
+func TestFieldPaths(t *testing.T) {
+	var record map[string]interface{}
+	err := json.Unmarshal([]byte(`{
+		"Account": {"Name": "ACME", "Rating": 0.1, "Owner": {"Name": "Joe", "Manager": null}},
+		"Contact": {"Account": null}
+	}`), &record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	account := &testAccount{Id: "A1", Revenue: 1.5, Owner: &testOwner{FullName: "Ann", Email: "ann@example.com", Age: 42},
+		Tags: []string{"x", "y"}, Extra: map[string]interface{}{"Code": 7}, secret: "s"}
+	flat := func(name string) (interface{}, bool) {
+		if name == "Legacy.Name" {
+			return "flat", true
+		}
+		return nil, false
+	}
+	context := NewContext().AddValues(flat).AddValues(Record(record)).AddValues(Record(map[string]interface{}{"acc": account}))
+	tests := []struct {
+		src   string
+		value interface{}
+	}{
+		{"Account.Name", "ACME"},
+		{"Account.Rating == 0.1", true},
+		{"Account.Owner.Name & '!'", "Joe!"},
+		{"Account.Owner.Manager.Name", nil},
+		{"Account.Missing.Name", nil},
+		{"Contact.Account.Owner.Name", nil},
+		{"ISNULL(Contact.Account.Name)", true},
+		{"Legacy.Name", "flat"},
+		{"acc.Id", "A1"},
+		{"acc.revenue * 2", big.NewRat(3, 1)},
+		{"acc.Owner.name", "Ann"},
+		{"acc.Owner.mail", "ann@example.com"},
+		{"acc.Owner.Age + 1", big.NewRat(43, 1)},
+		{"acc.Owner.Manager.FullName", nil},
+		{"'y' in acc.Tags", true},
+		{"acc.Extra.Code", big.NewRat(7, 1)},
+	}
+	for _, test := range tests {
+		mustResultIn(t, context, Salesforce, test.src, test.value)
+	}
+	for _, src := range []string{"Unknown.Name", "Account.Name.First", "acc.secret", "acc.Owner.Phone"} {
+		mustErrorIn(t, context, Standard, src)
+	}
+	if v, ok := Record(account)("Owner.Manager.Age"); !ok || v != nil {
+		t.Error("nil intermediate record should give null:", v, ok)
+	}
+	if _, ok := Record(account)("Missing"); ok {
+		t.Error("unknown field resolved")
+	}
+}
+
+func TestNullSafe(t *testing.T) {
+	var record map[string]interface{}
+	err := json.Unmarshal([]byte(`{"Account": {"Name": "ACME", "Owner": null, "Tags": ["x"]}, "Blank": null}`), &record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	context := NewContext().AddValues(Record(record))
+	tests := []struct {
+		src   string
+		value interface{}
+	}{
+		{"Account?.Name", "ACME"},
+		{"Account.Owner?.Name", nil},
+		{"Account.Owner?.Manager.Name ?? 'none'", "none"},
+		{"Lead?.Name", nil},
+		{"Lead?.Owner.Name ?? Account.Name", "ACME"},
+		{"Blank ?? Account.Tags[0]", "x"},
+		{"Account.Name ?? Lead.Name", "ACME"},
+		{"NULLVALUE(Blank, 'a') == (Blank ?? 'a')", true},
+		{"Blank ?? Blank", nil},
+		{"LET(x, null, x?.Name ?? 'x')", "x"},
+	}
+	for _, test := range tests {
+		mustResultIn(t, context, Standard, test.src, test.value)
+	}
+	for _, src := range []string{"Lead.Name", "Account?.Name?.First", "Blank ?? Lead", "1?.x"} {
+		mustErrorIn(t, context, Standard, src)
+	}
+}
+
+func TestIndex(t *testing.T) {
+	var record map[string]interface{}
+	if err := json.Unmarshal([]byte(`{"Lines": [{"Qty": 2}, {"Qty": 5}], "Codes": {"a b": "x"}}`), &record); err != nil {
+		t.Fatal(err)
+	}
+	context := NewContext().AddValues(Record(record))
+	strict := NewContext().AddValues(Record(record)).SetStrict(true)
+	tests := []struct {
+		src   string
+		value interface{}
+	}{
+		{"['a', 'b', 'c'][0]", "a"},
+		{"['a', 'b', 'c'][-1]", "c"},
+		{"['a', 'b', 'c'][1 + 1]", "c"},
+		{"Lines[1].Qty == 5", true},
+		{"Lines[-2]['Qty'] == 2", true},
+		{"Codes['a b']", "x"},
+		{"null[0]", nil},
+		{"Lines[null]", nil},
+		{"['a'][1]", nil},
+		{"['a'][-2]", nil},
+		{"['a'][100000000000000000000]", nil},
+		{"Codes['b']", nil},
+	}
+	for _, test := range tests {
+		mustResultIn(t, context, Standard, test.src, test.value)
+		e, _ := ParseString(test.src)
+		if _, err := e.Eval(strict); (err == nil) == (test.value == nil && !strings.Contains(test.src, "null")) {
+			t.Error("wrong result in strict mode:", test.src, err)
+		}
+	}
+	for _, src := range []string{"['a'][0.5]", "['a']['0']", "Codes[0]", "'abc'[0]", "1[0]"} {
+		mustErrorIn(t, context, Standard, src)
+	}
+}
+
+func TestLambdas(t *testing.T) {
+	var record map[string]interface{}
+	err := json.Unmarshal([]byte(`{"Items": [
+		{"name": "b", "amount": 150, "tags": ["x"]},
+		{"name": "a", "amount": 50, "tags": []},
+		{"name": "c", "amount": 200, "tags": ["x", "y"]}
+	], "x": "outer", "limit": 100}`), &record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flat := func(name string) (interface{}, bool) {
+		if name == "x.amount" {
+			return big.NewRat(-1, 1), true
+		}
+		return nil, false
+	}
+	context := NewContext().AddValues(flat).AddValues(Record(record))
+	list := func(elems ...interface{}) []interface{} {
+		return elems
+	}
+	n := func(x int64) *big.Rat {
+		return big.NewRat(x, 1)
+	}
+	tests := []struct {
+		src   string
+		value interface{}
+	}{
+		{"map(Items, x -> x.name)", list("b", "a", "c")},
+		{"map(filter(Items, x -> x.amount > limit), x -> x.name)", list("b", "c")},
+		{"any(Items, i -> i.amount < 100)", true},
+		{"all(Items, i -> i.amount < 100)", false},
+		{"all([], i -> false) && !any([], i -> true)", true},
+		{"find_first(Items, x -> 'y' in x.tags).name", "c"},
+		{"find_first(Items, x -> x.amount > 1000)", nil},
+		{"reduce(Items, 0, (sum, x) -> sum + x.amount)", n(400)},
+		{"map(sort_by(Items, x -> x.name), x -> x.name)", list("a", "b", "c")},
+		{"map(sort_by(Items, x -> -x.amount), x -> x.amount)", list(n(200), n(150), n(50))},
+		{"sort_by([3, null, 1, 2], x -> x)", list(nil, n(1), n(2), n(3))},
+		{"x + map(Items, i -> x)[0]", "outerouter"},
+		{"map([1, 2], x -> map([10, 20], y -> x * y))", list(list(n(10), n(20)), list(n(20), n(40)))},
+		{"map([1, 2], x -> x + reduce([x], 0, (x, y) -> x + y))", list(n(2), n(4))},
+		{"map(null, x -> x)", nil},
+		{"filter([1, null, 3], x -> x > 1)", list(n(3))},
+	}
+	for _, test := range tests {
+		mustResultIn(t, context, Standard, test.src, test.value)
+	}
+	if _, err := ParseString("filter(Items, x -> x.amount > limit).map(x -> x.name)"); err == nil {
+		t.Error("parse error expected: method call on list")
+	}
+	for _, src := range []string{"map(Items, 1)", "map(Items, (a, b) -> a)", "reduce(Items, 0, x -> x)",
+		"filter([1], x -> x)", "map('a', x -> x)", "sort_by([1, 'a'], x -> x)", "if(true, x -> x, 1)"} {
+		mustErrorIn(t, context, Standard, src)
+	}
+}
+
+func TestLet(t *testing.T) {
+	calls := 0
+	counting := func(name string, args []interface{}) (interface{}, error) {
+		if name == "COUNT" {
+			calls++
+			return big.NewRat(int64(calls), 1), nil
+		}
+		return nil, NOFUNC{}
+	}
+	values := func(name string) (interface{}, bool) {
+		switch name {
+		case "x":
+			return "  a long text value  ", true
+		case "t":
+			return "outer", true
+		}
+		return nil, false
+	}
+	context := NewContext().AddValues(values).AddFunctions(counting)
+	tests := []struct {
+		src   string
+		value interface{}
+	}{
+		{"LET(t, TRIM(x), IF(LEN(t) > 10, LEFT(t, 10), t))", "a long tex"},
+		{"LET(c, count(), c + c + c) == 3", true},
+		{"LET(a, 1, b, a + 1, a = b - 1)", true},
+		{"LET(t, 'inner', t) & t", "innerouter"},
+		{"LET(n, 2, MAP([1, 2], x -> x * n))[1] = 4", true},
+		{"LET(a, 1, LET(a, a + 1, a)) = 2", true},
+		{"LET(x, null, ISNULL(x))", true},
+	}
+	for _, test := range tests {
+		mustResultIn(t, context, Salesforce, test.src, test.value)
+	}
+	if calls != 1 {
+		t.Error("value of LET evaluated more than once:", calls)
+	}
+	mustErrorIn(t, context, Standard, "LET(a, unknown, 1)")
+}
+
+func TestScript(t *testing.T) {
+	tests := []struct {
+		src   string
+		value interface{}
+	}{
+		{"a := 2\nb := a * a\nb + 1", big.NewRat(5, 1)},
+		{"string_a := 'local'; string_a & string_b", "localb string"},
+		{"t := TRIM('  x  ')\nt := t & t\nLEN(t)", big.NewRat(2, 1)},
+		{"n := 3; MAP([1, 2], x -> x * n)[1]", big.NewRat(6, 1)},
+		{"a := 1", nil},
+		{"", nil},
+		{"number_1 + 1 // comment\n", big.NewRat(2, 1)},
+	}
+	for _, test := range tests {
+		s, err := Salesforce.ParseScript([]byte(test.src))
+		if err != nil {
+			t.Error("failed to parse:", test.src, err)
+			continue
+		}
+		v, err := s.Eval(test_context)
+		checkResult(t, test.src, v, err, test.value)
+	}
+	s, _ := ParseScript([]byte("string_a := 'shadowed'\nstring_a"))
+	s.Eval(test_context)
+	e, _ := ParseString("string_a")
+	if v, err := e.Eval(test_context); v != "a string" || err != nil {
+		t.Error("assignment visible outside of script:", v, err)
+	}
+	s, _ = ParseScript([]byte("a := 1\nb := unknown\na"))
+	if v, err := s.Eval(test_context); err == nil {
+		t.Error("no error returned on evaluate:", s, "instead value returned:", v)
+	}
+}
+
+func TestDefine(t *testing.T) {
+	c := NewContext()
+	c.AddValues(func(name string) (interface{}, bool) {
+		if name == "rate" {
+			return big.NewRat(1, 5), true
+		}
+		return nil, false
+	})
+	defs := []struct {
+		name   string
+		params []string
+		body   string
+	}{
+		{"tax", []string{"amount"}, "amount * rate"},
+		{"NET", []string{"gross", "rate"}, "gross - gross * rate"},
+		{"GREET", []string{"name"}, "'Hello ' + name"},
+		{"LEN", []string{"s"}, "42"},
+		{"PING", []string{"n"}, "PONG(n)"},
+		{"PONG", []string{"n"}, "PING(n)"},
+	}
+	for _, d := range defs {
+		if err := c.Define(d.name, d.params, d.body); err != nil {
+			t.Error("failed to define:", d.name, err)
+		}
+	}
+	tests := []struct {
+		src   string
+		value interface{}
+	}{
+		{"TAX(100)", big.NewRat(20, 1)},
+		{"net(100, 1/10)", big.NewRat(90, 1)},
+		{"NET(TAX(100), 1/2)", big.NewRat(10, 1)},
+		{"LET(amount, 5, TAX(10) + amount)", big.NewRat(7, 1)},
+		{"MAP([1, 2], gross -> NET(10, gross / 10))[1]", big.NewRat(8, 1)},
+		{"GREET('Ann')", "Hello Ann"},
+		{"LEN('abc')", big.NewRat(42, 1)},
+	}
+	for _, test := range tests {
+		mustResultIn(t, c, Standard, test.src, test.value)
+	}
+	for _, src := range []string{"TAX(1, 2)", "PING(1)", "GREET(1)", "TAX(x)"} {
+		mustErrorIn(t, c, Standard, src)
+	}
+	e, _ := ParseString("PING(1)")
+	if _, err := e.Eval(c); err == nil || !strings.Contains(err.Error(), "recursive call of PING") {
+		t.Error("recursion not reported:", err)
+	}
+	errs := []struct {
+		name   string
+		params []string
+		body   string
+	}{
+		{"F", []string{"x"}, "x +"},
+		{"F", []string{"x"}, "F(x - 1)"},
+		{"F", []string{"x"}, "TAX(x, 1)"},
+		{"F", []string{"x"}, "'a' * x"},
+		{"F", []string{"x"}, "x > 1 ? 'big' + 1 : 'small'"},
+		{"F", []string{"x"}, "NOT(x) && 1"},
+		{"F", []string{"x"}, "x IN 'abc'"},
+		{"F", nil, "G(a '"},
+		{"F", nil, "G(a /* x"},
+		{"F", []string{"x", "x"}, "x"},
+		{"F", []string{"1x"}, "1"},
+		{"F G", nil, "1"},
+	}
+	for _, d := range errs {
+		if err := c.Define(d.name, d.params, d.body); err == nil {
+			t.Error("no error returned on define:", d.name, d.params, d.body)
+		}
+	}
+	if _, ok := c.defined["F"]; ok {
+		t.Error("invalid definition added")
+	}
+	if err := c.Define("F", []string{"d"}, "d - DATE(2020, 1, 1) > 0 && d + 1 > d"); err != nil {
+		t.Error("failed to define:", err)
+	}
+}
+
+func TestTemplate(t *testing.T) {
+	var record map[string]interface{}
+	err := json.Unmarshal([]byte(`{"Account": {"Name": "Smith & \"Sons\"", "Tags": ["a", "b"]}}`), &record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	context := NewContext().AddValues(Record(record)).AddValues(test_values).SetTimeZone(time.UTC)
+	tests := []struct {
+		src    string
+		escape Escape
+		text   string
+	}{
+		{"Renewal for {!Account.Name} due {!DATE(2024, 3, 1) + 30}", EscapeNone, `Renewal for Smith & "Sons" due 2024-03-31`},
+		{"<b>${Account.Name}</b>", EscapeHTML, "<b>Smith &amp; &#34;Sons&#34;</b>"},
+		{`{"name": "${ Account.Name }"}`, EscapeJSON, `{"name": "Smith & \"Sons\""}`},
+		{"${number_1 / 4}, ${ {string_a} + '}' }, ${Account.Owner ?? 'none'}|${null}", EscapeNone, "0.25, a string}, none|"},
+		{"tags: ${Account.Tags}, ${number_1 > 0}", EscapeNone, "tags: a, b, true"},
+		{`\${number_1} {number_1} $ \{!x}`, EscapeNone, `${number_1} {number_1} $ {!x}`},
+		{"", EscapeNone, ""},
+	}
+	for _, test := range tests {
+		tmpl, err := ParseTemplate(test.src)
+		if err != nil {
+			t.Error("failed to parse:", test.src, err)
+			continue
+		}
+		if s, err := tmpl.Render(context, test.escape); err != nil || s != test.text {
+			t.Error("failed to render:", test.src, "expected:", test.text, "actual:", s, err)
+		}
+	}
+	if tmpl, err := ParseTemplate("a {!b} ${c}"); err != nil || len(tmpl.Exprs()) != 2 {
+		t.Error("expressions of placeholders expected:", err)
+	}
+	parseErrors := []struct {
+		src    string
+		line   int
+		column int
+	}{
+		{"Hello {!name", 1, 7},
+		{"Hello\n  ${ 1 + }", 2, 10},
+		{"${'}", 1, 3},
+		{"x ${a} ${b)}", 1, 11},
+	}
+	for _, test := range parseErrors {
+		_, err := ParseTemplate(test.src)
+		var pe *ParseError
+		if !errors.As(err, &pe) {
+			t.Error("parse error expected:", test.src, err)
+			continue
+		}
+		if pe.Line != test.line || pe.Column != test.column {
+			t.Error("wrong error position:", test.src, pe.Line, pe.Column, pe)
+		}
+	}
+	tmpl, _ := ParseTemplate("ok ${number_1}\n  due ${1 / 0}")
+	_, err = tmpl.Render(context, EscapeNone)
+	var te *TemplateError
+	if !errors.As(err, &te) || te.Line != 2 || te.Column != 7 || te.Placeholder != "${1 / 0}" {
+		t.Error("template error expected:", err)
+	}
+	if !errors.As(err, &DivisionByZeroError{}) {
+		t.Error("cause of template error expected:", err)
+	}
+	tmpl, _ = ParseTemplate("${Account}")
+	if s, err := tmpl.Render(context, EscapeNone); err == nil {
+		t.Error("no error returned on render of record, instead text returned:", s)
+	}
+}
+
+type testStatus string
+
+type testLevel int
+
+func (l testLevel) String() string {
+	return [...]string{"low", "high"}[l]
+}
+
+type testPath []string
+
+func (p testPath) String() string {
+	return strings.Join(p, "/")
+}
+
+type testCode struct{ a, b byte }
+
+func (c testCode) MarshalText() ([]byte, error) {
+	return []byte{c.a, '-', c.b}, nil
+}
+
+func TestGoValues(t *testing.T) {
+	n := 7
+	var none *int
+	values := map[string]interface{}{
+		"i8":       int8(-3),
+		"u64":      uint64(math.MaxUint64),
+		"f":        0.1,
+		"f32":      float32(0.5),
+		"ptr":      &n,
+		"nilPtr":   none,
+		"number":   json.Number("12.5"),
+		"status":   testStatus("open"),
+		"level":    testLevel(1),
+		"path":     testPath{"a", "b"},
+		"code":     testCode{'a', 'b'},
+		"name":     sql.NullString{String: "Ann", Valid: true},
+		"noName":   sql.NullString{},
+		"count":    sql.NullInt64{Int64: 4, Valid: true},
+		"when":     sql.NullTime{Time: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), Valid: true},
+		"big":      big.NewInt(1 << 40),
+		"ints":     []int{1, 2, 3},
+		"pair":     [2]testStatus{"a", "b"},
+		"bytes":    []byte("raw"),
+		"record":   map[string]int{"Qty": 2},
+		"rates":    map[string]interface{}{"Tax": 0.1},
+		"inf":      math.Inf(1),
+		"channel":  make(chan int),
+		"elements": []interface{}{uint8(1), "x", nil},
+	}
+	resolve := func(name string) (interface{}, bool) {
+		v, ok := values[name]
+		return v, ok
+	}
+	answer := func(name string, args []interface{}) (interface{}, error) {
+		if name == "ANSWER" {
+			return int64(42), nil
+		}
+		return nil, NOFUNC{}
+	}
+	context := NewContext().AddValues(resolve).AddFunctions(answer)
+	tests := []struct {
+		src   string
+		value interface{}
+	}{
+		{"i8 * 2", big.NewRat(-6, 1)},
+		{"u64", new(big.Rat).SetUint64(math.MaxUint64)},
+		{"f", new(big.Rat).SetFloat64(0.1)},
+		{"f == 0.1", false},
+		{"rates.Tax == 0.1", true},
+		{"f32 + 1", big.NewRat(3, 2)},
+		{"ptr + 1", big.NewRat(8, 1)},
+		{"nilPtr", nil},
+		{"number * 2", big.NewRat(25, 1)},
+		{"status + '!'", "open!"},
+		{"level + 1", big.NewRat(2, 1)},
+		{"path", "a/b"},
+		{"code", "a-b"},
+		{"name", "Ann"},
+		{"noName", nil},
+		{"count + 1", big.NewRat(5, 1)},
+		{"when == DATETIMEVALUE('2024-05-01T00:00:00Z')", true},
+		{"big", big.NewRat(1<<40, 1)},
+		{"SORT_BY(ints, x -> -x)[0]", big.NewRat(3, 1)},
+		{"pair[1]", "b"},
+		{"bytes", "raw"},
+		{"record.Qty", big.NewRat(2, 1)},
+		{"elements[0] + ANSWER()", big.NewRat(43, 1)},
+	}
+	for _, test := range tests {
+		mustResultIn(t, context, Standard, test.src, test.value)
+	}
+	for _, src := range []string{"inf", "channel"} {
+		mustErrorIn(t, context, Standard, src)
+	}
+	modes := []struct {
+		mode   FloatMode
+		places int
+		f      float64
+		value  *big.Rat
+	}{
+		{FloatExact, 0, 0.1, new(big.Rat).SetFloat64(0.1)},
+		{FloatShortest, 0, 0.1, big.NewRat(1, 10)},
+		{FloatHalfUp, 2, 0.1, big.NewRat(1, 10)},
+		{FloatHalfUp, 0, 0.1, big.NewRat(0, 1)},
+		{FloatHalfUp, 2, 2.675, big.NewRat(267, 100)}, // binary value is slightly less
+		{FloatHalfUp, 2, 0.125, big.NewRat(13, 100)},
+		{FloatHalfEven, 2, 0.125, big.NewRat(12, 100)},
+		{FloatDown, 2, 0.125, big.NewRat(12, 100)},
+		{FloatHalfUp, 0, -2.5, big.NewRat(-3, 1)},
+		{FloatHalfEven, 0, -2.5, big.NewRat(-2, 1)},
+		{FloatHalfEven, 0, 3.5, big.NewRat(4, 1)},
+		{FloatHalfEven, 0, 3.6, big.NewRat(4, 1)},
+		{FloatDown, 0, -2.9, big.NewRat(-2, 1)},
+		{FloatHalfEven, -1, 25, big.NewRat(20, 1)},
+		{FloatShortest, 2, 0.125, big.NewRat(1, 8)},
+	}
+	for _, m := range modes {
+		values["f"] = m.f
+		context.SetFloatMode(m.mode, m.places)
+		mustResultIn(t, context, Standard, "f", m.value)
+		mustResultIn(t, context, Standard, "rates.Tax", big.NewRat(1, 10))
 	}
 }
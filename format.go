@@ -0,0 +1,329 @@
+package eval
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Printer formats expressions as canonical source: operators are separated by spaces,
+// parentheses are used only where required by precedence, function names and keywords
+// are in upper case. Calls which do not fit into Width are split with one argument per
+// line indented by Indent.
+type Printer struct {
+	Indent  string  // indentation of one level of arguments of split calls
+	Width   int     // maximum line width, zero means that calls are never split
+	Dialect Dialect // operators are printed as written in the dialect
+}
+
+// Format returns canonical source of the expression on a single line. For expressions
+// returned by Parse, Parse(Format(e)) returns equal expression.
+func Format(e Expr) string {
+	return (&Printer{}).Format(e)
+}
+
+// Format returns canonical source of the expression
+func (c *Printer) Format(e Expr) string {
+	p := printer{Printer: c}
+	p.expr(e)
+	return p.b.String()
+}
+
+type printer struct {
+	*Printer
+	b       strings.Builder
+	column  int  // characters written since the last new line
+	depth   int  // indentation level
+	newline bool // anything written next starts on a new line
+}
+
+func (p *printer) write(s string) {
+	if s == "" {
+		return
+	}
+	if p.newline {
+		p.newline = false
+		s = "\n" + strings.Repeat(p.Indent, p.depth) + strings.TrimLeft(s, " ")
+	}
+	p.b.WriteString(s)
+	if i := strings.LastIndexByte(s, '\n'); i >= 0 {
+		p.column = utf8.RuneCountInString(s[i+1:])
+	} else {
+		p.column += utf8.RuneCountInString(s)
+	}
+}
+
+// linebreak starts new line before anything written next
+func (p *printer) linebreak() {
+	p.newline = true
+}
+
+func (p *printer) comment(c Comment) {
+	p.write(c.Text)
+	p.newline = c.isLineComment()
+}
+
+func (p *printer) expr(e Expr) {
+	p.node(e, false)
+}
+
+// node prints expression with comments attached to it, comments are kept outside of
+// parentheses so that they are attached to the same node when parsed again
+func (p *printer) node(e Expr, paren bool) {
+	var comments *commentSet
+	if c, ok := e.(commented); ok {
+		comments = c.comments()
+	}
+	if comments != nil {
+		for _, c := range comments.leading {
+			p.comment(c)
+			if !p.newline {
+				p.write(" ")
+			}
+		}
+	}
+	if paren {
+		p.write("(")
+		p.body(e)
+		p.write(")")
+	} else {
+		p.body(e)
+	}
+	if comments != nil {
+		for _, c := range comments.trailing {
+			p.write(" ")
+			p.comment(c)
+		}
+	}
+}
+
+func (p *printer) body(e Expr) {
+	switch x := e.(type) {
+	case *Ident:
+		p.write(quoteIdent(x.name, p.Dialect))
+	case *Selector:
+		if l, ok := x.x.(*Literal); ok && isRat(l.value) {
+			p.node(x.x, true) // 1.a would be scanned as number 1. followed by a
+		} else {
+			p.operand(x.x, operandPrec)
+		}
+		if x.safe {
+			p.write("?")
+		}
+		p.write("." + quoteIdent(x.sel, p.Dialect))
+	case *Index:
+		p.operand(x.x, operandPrec)
+		p.write("[")
+		p.expr(x.index)
+		p.write("]")
+	case *Literal:
+		p.write(literalString(x.value))
+	case *Call:
+		p.list(strings.ToUpper(x.name)+"(", x.args, ")")
+	case *List:
+		p.list("[", x.elems, "]")
+	case *Unary:
+		p.write(p.Dialect.repr(x.op))
+		p.operand(x.x, unaryPrec)
+	case *Binary:
+		left, right := prec[x.op], prec[x.op]+1 // binary operators associate to the left
+		if rightAssoc(x.op) {
+			left, right = right, left
+		}
+		p.operand(x.x, left)
+		p.write(" " + p.Dialect.repr(x.op) + " ")
+		p.operand(x.y, right)
+	case *Like:
+		p.operand(x.x, prec[x.op])
+		p.write(" " + repr(x.op) + " ")
+		p.operand(x.pattern, prec[x.op]+1)
+		if x.escape != nil {
+			p.write(" ESCAPE ")
+			p.operand(x.escape, prec[x.op]+1)
+		}
+	case *Script:
+		for i, stmt := range x.stmts {
+			if i > 0 {
+				p.linebreak()
+			}
+			p.expr(stmt)
+		}
+	case *Assign:
+		p.write(quoteIdent(x.name, p.Dialect) + " := ")
+		p.expr(x.x)
+	case *Lambda:
+		params := make([]string, len(x.params))
+		for i, name := range x.params {
+			params[i] = quoteIdent(name, p.Dialect)
+		}
+		if len(params) == 1 {
+			p.write(params[0])
+		} else {
+			p.write("(" + strings.Join(params, ", ") + ")")
+		}
+		p.write(" -> ")
+		p.expr(x.body)
+	case *Conditional:
+		p.operand(x.cond, condPrec+1)
+		p.write(" ? ")
+		p.expr(x.then)
+		p.write(" : ")
+		p.expr(x.els) // conditional operations associate to the right
+	case *BadExpr:
+		p.write("BAD")
+	}
+}
+
+// operand prints expression in parentheses if it binds weaker than prec1
+func isRat(v interface{}) bool {
+	_, ok := v.(*big.Rat)
+	return ok
+}
+
+func (p *printer) operand(x Expr, prec1 int) {
+	p.node(x, precedence(x) < prec1)
+}
+
+// list prints arguments of call or elements of list, they are split one per line if
+// they do not fit into the width
+func (p *printer) list(open string, args []Expr, close string) {
+	split := false
+	if p.Width > 0 && len(args) > 0 {
+		column := p.column
+		if p.newline {
+			column = utf8.RuneCountInString(strings.Repeat(p.Indent, p.depth))
+		}
+		flat := &printer{Printer: &Printer{Dialect: p.Dialect}}
+		flat.list(open, args, close)
+		split = column+utf8.RuneCountInString(flat.b.String()) > p.Width
+	}
+	p.write(open)
+	p.depth++
+	for i, a := range args {
+		if split {
+			p.linebreak()
+		}
+		p.expr(a)
+		if i < len(args)-1 {
+			if split {
+				p.write(",")
+			} else {
+				p.write(", ")
+			}
+		}
+	}
+	p.depth--
+	if split {
+		p.linebreak()
+	}
+	p.write(close)
+}
+
+func precedence(x Expr) int {
+	switch e := x.(type) {
+	case *Binary:
+		return prec[e.op]
+	case *Unary:
+		return unaryPrec
+	case *Like:
+		return prec[e.op]
+	case *Conditional, *Lambda, *Assign, *Script:
+		return condPrec
+	case *Literal:
+		if r, ok := e.value.(*big.Rat); ok && r.Sign() < 0 {
+			return unaryPrec // printed with minus sign
+		}
+	}
+	return operandPrec
+}
+
+var keywords = []string{"TRUE", "FALSE", "NULL", "IN", "NOT", "LIKE", "ILIKE"}
+
+// quoteIdent returns name of identifier as is if it can be scanned as identifier,
+// otherwise name in curly braces
+func quoteIdent(name string, dialect Dialect) string {
+	for _, k := range keywords {
+		if strings.EqualFold(name, k) {
+			return "{" + name + "}"
+		}
+	}
+	for i, r := range name {
+		if i == 0 && r == '$' && dialect == Salesforce {
+			continue
+		}
+		if !isLetter(r) && (i == 0 || !isDigit(r) && r != '_') {
+			return "{" + strings.NewReplacer("\\", "\\\\", "}", "\\}").Replace(name) + "}"
+		}
+	}
+	if name == "" {
+		return "{}"
+	}
+	return name
+}
+
+func literalString(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return "NULL"
+	case bool:
+		if x {
+			return "TRUE"
+		}
+		return "FALSE"
+	case string:
+		return quoteString(x)
+	case *big.Rat:
+		if s := numberString(x); isExact(x, s) {
+			return s
+		}
+		// no decimal literal for the number
+		return "(" + x.Num().String() + " / " + x.Denom().String() + ")"
+	case time.Time:
+		if IsDate(x) {
+			return "DATEVALUE(" + quoteString(x.Format("2006-01-02")) + ")"
+		}
+		return "DATETIMEVALUE(" + quoteString(x.Format(ISO8601)) + ")"
+	case []interface{}:
+		s := make([]string, len(x))
+		for i, v := range x {
+			s[i] = literalString(v)
+		}
+		return "[" + strings.Join(s, ", ") + "]"
+	}
+	return "BAD"
+}
+
+func isExact(r *big.Rat, s string) bool {
+	v, ok := new(big.Rat).SetString(s)
+	return ok && v.Cmp(r) == 0
+}
+
+// quoteString returns string literal in single quotes, characters which can not be
+// written as is are escaped
+func quoteString(s string) string {
+	var b strings.Builder
+	b.WriteByte('\'')
+	for _, r := range s {
+		switch r {
+		case '\\', '\'':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\r':
+			b.WriteString(`\r`)
+		default:
+			if r < ' ' || r == 0x7f {
+				fmt.Fprintf(&b, `\u%04x`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteByte('\'')
+	return b.String()
+}
@@ -0,0 +1,220 @@
+package eval
+
+import (
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func mustFormat(t *testing.T, src, expected string) {
+	t.Helper()
+	e, err := ParseString(src)
+	if err != nil {
+		t.Error("failed to parse:", src, err)
+		return
+	}
+	s := Format(e)
+	if s != expected {
+		t.Error("wrong format of:", src, "expected:", expected, "actual:", s)
+	}
+	r, err := ParseString(s)
+	if err != nil {
+		t.Error("failed to parse formatted:", s, err)
+		return
+	}
+	if !reflect.DeepEqual(e, r) {
+		t.Error("formatted expression parsed differently:", src, "tree:", tree(e), "formatted tree:", tree(r))
+	}
+	if e.String() != s {
+		t.Error("String differs from Format:", e.String(), s)
+	}
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		src    string
+		format string
+	}{
+		{"a+b*c", "a + b * c"},
+		{"(a+b)*c", "(a + b) * c"},
+		{"a-(b-c)", "a - (b - c)"},
+		{"(a-b)-c", "a - b - c"},
+		{"a/(b*c)", "a / (b * c)"},
+		{"((a))", "a"},
+		{"math.max(a, b).c", "MATH.MAX(a, b).c"},
+		{"1 .a + (1.5).b[0] + (-2)?.c", "(1).a + (1.5).b[0] + (-2)?.c"},
+		{"'x'.a + [1].b", "'x'.a + [1].b"},
+		{"a<b==(c>d)", "a < b == c > d"},
+		{"(a==b)<c", "(a == b) < c"},
+		{"a||(b&&c)", "a || b && c"},
+		{"(a||b)&&c", "(a || b) && c"},
+		{"a<>b", "a != b"},
+		{"-(a+b)", "-(a + b)"},
+		{"- - 1", "--1"},
+		{"!(!x)", "!!x"},
+		{"-a*b", "-a * b"},
+		{"-(a*b)", "-(a * b)"},
+		{"a**(b**c)", "a ^ b ^ c"},
+		{"(a^b)^c", "(a ^ b) ^ c"},
+		{"(-a)^2", "(-a) ^ 2"},
+		{"a%(b%c)", "a % (b % c)"},
+		{"if(a,b,c)", "IF(a, b, c)"},
+		{"Now()", "NOW()"},
+		{"true || False && null", "TRUE || FALSE && NULL"},
+		{"{true} + {first name} + {a}", "{true} + {first name} + a"},
+		{"Account.Name", "Account.Name"},
+		{"a ?. b ?? (c ?? d)", "a?.b ?? c ?? d"},
+		{"(a ?? b) ?? c", "(a ?? b) ?? c"},
+		{"(a ?? b) + c", "(a ?? b) + c"},
+		{"1.50 + 2e3 + 0.125", "1.5 + 2000 + 0.125"},
+		{`'say "hi"'`, `'say "hi"'`},
+		{`"it's" + 'it\'s'`, `'it\'s' + 'it\'s'`},
+		{`'a\\b\tc\nd\u00e9\u0001'`, `'a\\b\tc\ndé\u0001'`},
+		{`{a\}b} + {c\\d} + {e\f}`, `{a\}b} + {c\\d} + {e\\f}`},
+		{"a?b:c", "a ? b : c"},
+		{"a ? b : (c ? d : e)", "a ? b : c ? d : e"},
+		{"a ? (b ? c : d) : e", "a ? b ? c : d : e"},
+		{"(a ? b : c) ? d : e", "(a ? b : c) ? d : e"},
+		{"-(a ? b : c) * 2", "-(a ? b : c) * 2"},
+		{"(a || b) ? c : d", "a || b ? c : d"},
+		{"if(a, b ? c : d, e)", "IF(a, b ? c : d, e)"},
+		{"a in[1,'b',[ ]]", "a IN [1, 'b', []]"},
+		{"not (a not in b)", "NOT(a NOT IN b)"},
+		{"!(a in b)", "!(a IN b)"},
+		{"(a in b) in c", "a IN b IN c"},
+		{"{in} + {Not} + {note}", "{in} + {Not} + note"},
+		{"a like'x%'", "a LIKE 'x%'"},
+		{"a ilike b escape '#'", "a ILIKE b ESCAPE '#'"},
+		{"(a LIKE b) LIKE (c LIKE d)", "a LIKE b LIKE (c LIKE d)"},
+		{"{like} + {Escape}", "{like} + Escape"},
+		{"Account . Owner.{Name}", "Account.Owner.Name"},
+		{"{Account.Name} + {a}.{b.c}", "{Account.Name} + a.{b.c}"},
+		{"(a + b).c + f(x).{true}", "(a + b).c + F(x).{true}"},
+		{"-a.b", "-a.b"},
+		{"a [ 0 ] . b['c']", "a[0].b['c']"},
+		{"(a + b)[-1] + [1][0]", "(a + b)[-1] + [1][0]"},
+		{"a[b ? c : d]", "a[b ? c : d]"},
+		{"filter(l, x->x.a>1)", "FILTER(l, x -> x.a > 1)"},
+		{"reduce(l, 0, ( acc,x )->acc+x)", "REDUCE(l, 0, (acc, x) -> acc + x)"},
+		{"f(()->1, (x)->x, ({in})->{in})", "F(() -> 1, x -> x, {in} -> {in})"},
+	}
+	for _, test := range tests {
+		mustFormat(t, test.src, test.format)
+	}
+}
+
+func TestFormatLiterals(t *testing.T) {
+	tests := []struct {
+		value  interface{}
+		format string
+	}{
+		{big.NewRat(1, 3), "(1 / 3)"},
+		{big.NewRat(-5, 4), "-1.25"},
+		{"a\\b", `'a\\b'`},
+		{Date(2021, 3, 1), "DATEVALUE('2021-03-01')"},
+		{time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC), "DATETIMEVALUE('2021-03-01T00:00:00Z')"},
+	}
+	for _, test := range tests {
+		if s := Format(&Literal{value: test.value}); s != test.format {
+			t.Error("wrong format of:", test.value, "expected:", test.format, "actual:", s)
+		}
+	}
+	e := &Binary{x: &Literal{value: big.NewRat(2, 1)}, op: SUB, y: &Literal{value: big.NewRat(-1, 1)}}
+	if s := Format(e); s != "2 - -1" {
+		t.Error("wrong format of negative literal:", s)
+	}
+}
+
+func TestPrinter(t *testing.T) {
+	e, err := ParseString("if(contains(name, 'x'), left(name, 10), substitute(name, 'abc', 'def')) + 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &Printer{Indent: "  ", Width: 40}
+	expected := `IF(
+  CONTAINS(name, 'x'),
+  LEFT(name, 10),
+  SUBSTITUTE(name, 'abc', 'def')
+) + 1`
+	s := p.Format(e)
+	if s != expected {
+		t.Error("wrong format, expected:\n" + expected + "\nactual:\n" + s)
+	}
+	if r, err := ParseString(s); err != nil || !reflect.DeepEqual(e, r) {
+		t.Error("formatted expression parsed differently:", s, err)
+	}
+	if s := (&Printer{Indent: "  ", Width: 100}).Format(e); s != Format(e) {
+		t.Error("short call should not be split:", s)
+	}
+}
+
+func TestFormatComments(t *testing.T) {
+	tests := []struct {
+		src    string
+		format string
+	}{
+		{"a /* x */ + b", "a /* x */ + b"},
+		{"a / b // div", "a / b // div"},
+		{"// header\nif(a, // yes\n b, c /* no */)", "// header\nIF(a, // yes\nb, c /* no */)"},
+		{"/*c*/ -a", "/*c*/ -a"},
+		{"-/*c*/a", "-/*c*/ a"},
+		{"(a + b) /*sum*/ * c", "(a + b) /*sum*/ * c"},
+		{"/*a*/ ( /*b*/ x )", "/*a*/ /*b*/ x"},
+		{"f(/*none*/)", "F() /*none*/"},
+		{"1 /* one\n two */", "1 /* one\n two */"},
+		{"f(a // first\n, b)", "F(a // first\n, b)"},
+	}
+	for _, test := range tests {
+		mustFormat(t, test.src, test.format)
+	}
+	p := &Printer{Indent: "  ", Width: 20}
+	e, _ := ParseString("if(a > 100, 'large', // over limit\n 'small')")
+	if c := e.(*Call).Args()[2].(*Literal).Leading(); len(c) != 1 {
+		t.Error("comment after comma should lead the next argument:", c)
+	}
+	expected := "IF(\n  a > 100,\n  'large',\n  // over limit\n  'small'\n)"
+	if s := p.Format(e); s != expected {
+		t.Error("wrong format, expected:\n" + expected + "\nactual:\n" + s)
+	}
+}
+
+func TestCommentAttachment(t *testing.T) {
+	e, err := ParseString("/* total */ price * qty // items\n + tax /* vat */")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := e.(*Binary)
+	mul := sum.X().(*Binary)
+	if c := mul.X().(*Ident).Leading(); len(c) != 1 || c[0].Text != "/* total */" {
+		t.Error("wrong leading comments of price:", c)
+	}
+	if c := mul.Y().(*Ident).Trailing(); len(c) != 1 || c[0].Text != "// items" {
+		t.Error("wrong trailing comments of qty:", c)
+	}
+	if c := sum.Y().(*Ident).Trailing(); len(c) != 1 || c[0].Text != "/* vat */" {
+		t.Error("wrong trailing comments of tax:", c)
+	}
+	if len(sum.Leading()) != 0 || len(sum.Trailing()) != 0 || len(mul.Leading()) != 0 {
+		t.Error("comments attached to binary expressions")
+	}
+	_, err = ParseString("a + /* b")
+	if pe, ok := err.(*ParseError); !ok || pe.Message != "unterminated comment" || pe.Column != 5 {
+		t.Error("unterminated comment not reported:", err)
+	}
+}
+
+func TestFormatScript(t *testing.T) {
+	src := "x := f(a,\n b) // x\n\n;y:=x+1;y"
+	s, err := ParseScript([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "x := F(a, b) // x\ny := x + 1\ny"
+	if f := Format(s); f != expected {
+		t.Error("wrong format of script, expected:\n" + expected + "\nactual:\n" + f)
+	}
+	if r, err := ParseScript([]byte(Format(s))); err != nil || !reflect.DeepEqual(s, r) {
+		t.Error("formatted script parsed differently:", err)
+	}
+}
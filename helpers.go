@@ -83,3 +83,15 @@ func MinNumOfParams(args []interface{}, expected int) {
 		panic(fmt.Sprint("should have at least ", expected, " parameters, actual ", len(args)))
 	}
 }
+
+func NumOfArgs(args []*Arg, expected int) {
+	if expected != len(args) {
+		panic(fmt.Sprint("expected ", expected, " parameters, actual ", len(args)))
+	}
+}
+
+func MinNumOfArgs(args []*Arg, expected int) {
+	if expected > len(args) {
+		panic(fmt.Sprint("should have at least ", expected, " parameters, actual ", len(args)))
+	}
+}
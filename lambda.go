@@ -0,0 +1,229 @@
+package eval
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Higher order functions applying lambdas to elements of lists, see lazyBuiltins. Null
+// list gives null, null result of predicate is taken for false.
+
+func mapList(args []*Arg, context Context) (interface{}, error) {
+	NumOfArgs(args, 2)
+	fn := lambdaArg(args, 1, 1)
+	list, err := listArg(args, 0)
+	if list == nil || err != nil {
+		return nil, err
+	}
+	result := make([]interface{}, len(list))
+	for i, x := range list {
+		if result[i], err = fn.Apply(context, x); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func filterList(args []*Arg, context Context) (interface{}, error) {
+	NumOfArgs(args, 2)
+	fn := lambdaArg(args, 1, 1)
+	list, err := listArg(args, 0)
+	if list == nil || err != nil {
+		return nil, err
+	}
+	result := make([]interface{}, 0, len(list))
+	for _, x := range list {
+		ok, err := test(fn, context, x)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			result = append(result, x)
+		}
+	}
+	return result, nil
+}
+
+// quantifier returns function testing elements until the first one for which predicate
+// gives stop, which is the result
+func quantifier(stop bool) func(args []*Arg, context Context) (interface{}, error) {
+	return func(args []*Arg, context Context) (interface{}, error) {
+		NumOfArgs(args, 2)
+		fn := lambdaArg(args, 1, 1)
+		list, err := listArg(args, 0)
+		if list == nil || err != nil {
+			return nil, err
+		}
+		for _, x := range list {
+			ok, err := test(fn, context, x)
+			if err != nil {
+				return nil, err
+			}
+			if ok == stop {
+				return stop, nil
+			}
+		}
+		return !stop, nil
+	}
+}
+
+func findFirst(args []*Arg, context Context) (interface{}, error) {
+	NumOfArgs(args, 2)
+	fn := lambdaArg(args, 1, 1)
+	list, err := listArg(args, 0)
+	if list == nil || err != nil {
+		return nil, err
+	}
+	for _, x := range list {
+		ok, err := test(fn, context, x)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return x, nil
+		}
+	}
+	return nil, nil
+}
+
+// reduce(list, initial, (acc, x) -> ...) applies lambda to the accumulated value and
+// each element
+func reduce(args []*Arg, context Context) (interface{}, error) {
+	NumOfArgs(args, 3)
+	fn := lambdaArg(args, 2, 2)
+	list, err := listArg(args, 0)
+	if list == nil || err != nil {
+		return nil, err
+	}
+	acc, err := args[1].Value()
+	if err != nil {
+		return nil, err
+	}
+	for _, x := range list {
+		if acc, err = fn.Apply(context, acc, x); err != nil {
+			return nil, err
+		}
+	}
+	return acc, nil
+}
+
+// sortBy returns list sorted by keys given by lambda in ascending order, null keys are
+// first, elements with equal keys keep their order
+func sortBy(args []*Arg, context Context) (interface{}, error) {
+	NumOfArgs(args, 2)
+	fn := lambdaArg(args, 1, 1)
+	list, err := listArg(args, 0)
+	if list == nil || err != nil {
+		return nil, err
+	}
+	keys := make([]interface{}, len(list))
+	for i, x := range list {
+		if keys[i], err = fn.Apply(context, x); err != nil {
+			return nil, err
+		}
+	}
+	index := make([]int, len(list))
+	for i := range index {
+		index[i] = i
+	}
+	location := context.cast().localTimeZone
+	sort.SliceStable(index, func(i, j int) bool {
+		c, e := compareValues(keys[index[i]], keys[index[j]], location)
+		if e != nil && err == nil {
+			err = e
+		}
+		return c < 0
+	})
+	if err != nil {
+		return nil, err
+	}
+	result := make([]interface{}, len(list))
+	for i, k := range index {
+		result[i] = list[k]
+	}
+	return result, nil
+}
+
+// listArg returns list given by argument, nil if the argument is null
+func listArg(args []*Arg, index int) ([]interface{}, error) {
+	v, err := args[index].Value()
+	if v == nil || err != nil {
+		return nil, err
+	}
+	list, ok := v.([]interface{})
+	if !ok {
+		panic(fmt.Sprint("parameter ", index, " not a list ", v))
+	}
+	return list, nil
+}
+
+// lambdaArg returns lambda given as argument, it must have n parameters
+func lambdaArg(args []*Arg, index, n int) *Lambda {
+	fn, ok := args[index].Expr().(*Lambda)
+	if !ok {
+		panic(fmt.Sprint("parameter ", index, " not a lambda"))
+	}
+	if len(fn.params) != n {
+		panic(fmt.Sprint("lambda of parameter ", index, " expects ", n, " parameters, actual ", len(fn.params)))
+	}
+	return fn
+}
+
+// test applies predicate to x, null is taken for false
+func test(fn *Lambda, context Context, x interface{}) (bool, error) {
+	v, err := fn.Apply(context, x)
+	if v == nil || err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, errors.New("not a boolean:" + fmt.Sprint(v))
+	}
+	return b, nil
+}
+
+// compareValues compares values of the same type, null is less than any other value
+func compareValues(x, y interface{}, location *time.Location) (int, error) {
+	if x == nil || y == nil {
+		switch {
+		case x != nil:
+			return 1, nil
+		case y != nil:
+			return -1, nil
+		}
+		return 0, nil
+	}
+	switch a := x.(type) {
+	case *big.Rat:
+		if b, ok := y.(*big.Rat); ok {
+			return a.Cmp(b), nil
+		}
+		return 0, errors.New("not a number:" + fmt.Sprint(y))
+	case string:
+		if b, ok := y.(string); ok {
+			return strings.Compare(a, b), nil
+		}
+		return 0, errors.New("not a string:" + fmt.Sprint(y))
+	case bool:
+		if b, ok := y.(bool); ok {
+			switch {
+			case a == b:
+				return 0, nil
+			case b:
+				return -1, nil
+			}
+			return 1, nil
+		}
+		return 0, errors.New("not a boolean:" + fmt.Sprint(y))
+	case time.Time:
+		if b, ok := y.(time.Time); ok {
+			return compareDates(a, b, location), nil
+		}
+		return 0, errors.New("not a date:" + fmt.Sprint(y))
+	}
+	return 0, errors.New("not comparable:" + fmt.Sprint(x))
+}
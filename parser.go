@@ -1,7 +1,6 @@
 package eval
 
 import (
-	"errors"
 	"fmt"
 	"math/big"
 	"strings"
@@ -9,8 +8,13 @@ import (
 
 type parser struct {
 	scanner scanner
-	tok     token
+	tok     Token
 	lit     string
+	pos     int  // offset of the current token
+	end     int  // offset just after the current token
+	all     bool // continue after syntax errors instead of stopping at the first one
+	errors  []*ParseError
+	pending []Comment // comments not yet attached to a node
 }
 
 // ParseString parses string and returns expression or error
@@ -18,132 +22,528 @@ func ParseString(src string) (expr Expr, err error) {
 	return Parse([]byte(src))
 }
 
+// Parse parses source and returns expression or error. Syntax errors are returned
+// as *ParseError.
 func Parse(src []byte) (expr Expr, err error) {
+	return Standard.Parse(src)
+}
+
+// ParseAll parses source and returns all syntax errors found instead of stopping at the
+// first one. Parser resynchronises at commas and parentheses, parts of the source which
+// could not be parsed are replaced by placeholders which fail to evaluate, so the returned
+// expression is never nil.
+func ParseAll(src []byte) (Expr, []*ParseError) {
+	return Standard.ParseAll(src)
+}
+
+// ParseScript parses script: statements separated by semicolons or new lines. New line
+// ends statement if it follows identifier, literal or closing parenthesis or bracket
+// which is not nested in parentheses or brackets, so expression continued on the next
+// line must be broken after an operator.
+func ParseScript(src []byte) (*Script, error) {
+	return Standard.ParseScript(src)
+}
+
+func parseScript(src []byte, dialect Dialect) (script *Script, err error) {
+	p := &parser{}
 	defer func() {
 		if r := recover(); r != nil {
-			var ok bool
-			if err, ok = r.(ScannerError); !ok {
-				err = errors.New(fmt.Sprint(r))
-			}
+			p.recovered(r)
+			script, err = nil, p.errors[0]
 		}
 	}()
-	p := parser{}
-	p.scanner = newScanner(src)
+	p.scanner = newScanner(src, dialect, p.report)
+	p.scanner.script = true
 	p.next()
-	expr = p.parseExpr(nil)
+	script = &Script{stmts: make([]Expr, 0)}
+	for p.tok != EOE {
+		if p.tok == SEMICOLON {
+			p.next()
+			continue
+		}
+		script.stmts = append(script.stmts, p.parseStmt())
+		if p.tok != SEMICOLON && p.tok != EOE {
+			p.error("semicolon or new line expected", ";")
+		}
+	}
+	return p.trail(script).(*Script), nil
+}
+
+// parseStmt parses assignment or expression
+func (p *parser) parseStmt() Expr {
+	x := p.parseExpr()
+	if p.tok != ASSIGN {
+		return x
+	}
+	ident, ok := x.(*Ident)
+	if !ok {
+		p.error("assignment to " + Format(x))
+		return x
+	}
 	p.next()
-	if p.tok != EOE {
-		return nil, p.scanner.newError("no matching opening parenthesis")
+	return &Assign{name: ident.name, x: p.parseExpr()}
+}
+
+func parse(src []byte, all bool, dialect Dialect) (expr Expr, errs []*ParseError) {
+	p := &parser{all: all}
+	defer func() {
+		if r := recover(); r != nil {
+			p.recovered(r)
+			expr, errs = &BadExpr{from: 0, to: len(src)}, p.errors
+		}
+	}()
+	p.scanner = newScanner(src, dialect, p.report)
+	p.next()
+	expr = p.parseExpr()
+	for p.tok != EOE {
+		switch p.tok {
+		case RPAREN:
+			p.error("no matching opening parenthesis")
+			p.next()
+		case RBRACK:
+			p.error("no matching opening bracket")
+			p.next()
+		case ARROW:
+			p.error("lambda allowed only as function argument")
+			p.next()
+			if p.tok != EOE {
+				p.parseExpr() // checked for errors only
+			}
+			continue
+		case COMMA, COLON, BAD:
+			if p.tok == COMMA {
+				p.error("unexpected comma")
+			} else if p.tok == COLON {
+				p.error("unexpected colon")
+			} else {
+				p.error("unexpected character: " + p.lit)
+			}
+			p.next()
+			if p.tok != EOE {
+				p.parseExpr() // checked for errors only
+			}
+			continue
+		default:
+			p.error("operator expected")
+			p.parseExpr() // checked for errors only
+			continue
+		}
+		if prec[p.tok] > 0 || p.tok == QUESTION {
+			expr = p.parseConditional(p.parseBinaryExpr(expr, 1))
+		}
 	}
-	return
+	return expr, p.errors
 }
 
 // ParseIdent is shortcut function to get value from context
 func ParseIdent(name string) Expr {
-	return &ident{name: name}
+	return &Ident{name: name}
 }
 
 func (p *parser) next() {
 	p.tok, p.lit = p.scanner.scan()
+	p.pos, p.end = p.scanner.tokOffset, p.scanner.offset
+	p.pending = append(p.pending, p.scanner.comments...)
 }
 
-func (p *parser) parseExpr(x Expr) Expr {
-	if x == nil {
-		x = p.parseUnaryExpr()
+// lead attaches comments found before start of an operand to it
+func lead(x Expr, comments []Comment) Expr {
+	if c, ok := x.(commented); ok && len(comments) > 0 {
+		c.comments().leading = append(comments, c.comments().leading...)
+	}
+	return x
+}
+
+// trail attaches pending comments to x as trailing comments. Comments found after an
+// operand but before the following operator, comma or parenthesis are attached to it.
+func (p *parser) trail(x Expr) Expr {
+	if c, ok := x.(commented); ok && len(p.pending) > 0 {
+		c.comments().trailing = append(c.comments().trailing, p.pending...)
+		p.pending = nil
+	}
+	return x
+}
+
+// recovered records r recovered from panic of parsing, so that there is at least one error
+func (p *parser) recovered(r interface{}) {
+	pe, ok := r.(*ParseError)
+	if !ok {
+		pe = p.scanner.errorAt(p.pos, p.end-p.pos, fmt.Sprint(r))
+	}
+	if !ok || len(p.errors) == 0 {
+		p.errors = append(p.errors, pe)
+	}
+}
+
+// report records syntax error, parsing stops at the first error unless all errors are
+// requested
+func (p *parser) report(err *ParseError) {
+	if n := len(p.errors); n > 0 && p.errors[n-1].Offset == err.Offset {
+		return // one error per position is enough
+	}
+	p.errors = append(p.errors, err)
+	if !p.all {
+		panic(err)
+	}
+}
+
+// error reports syntax error at the current token
+func (p *parser) error(msg string, expected ...string) {
+	p.report(p.scanner.errorAt(p.pos, p.end-p.pos, msg, expected...))
+}
+
+// sync skips tokens up to the next comma, closing parenthesis, closing bracket or end
+// of expression which is not nested in parentheses or brackets and returns placeholder
+// for the skipped source
+func (p *parser) sync(from int) Expr {
+	to := p.pos
+	for depth := 0; p.tok != EOE; p.next() {
+		if p.tok == LPAREN || p.tok == LBRACK {
+			depth++
+		} else if p.tok == RPAREN || p.tok == RBRACK || p.tok == COMMA {
+			if depth == 0 {
+				break
+			}
+			if p.tok != COMMA {
+				depth--
+			}
+		}
+		to = p.end
 	}
-	if p.tok == RPAREN || p.tok == EOE || p.tok == COMMA {
+	return &BadExpr{from: from, to: to}
+}
+
+// parseExpr parses complete expression
+func (p *parser) parseExpr() Expr {
+	return p.parseConditional(p.parseBinaryExpr(nil, 1))
+}
+
+// parseConditional parses conditional operation if already parsed cond is followed by
+// question mark. Conditional operations are associated to the right.
+func (p *parser) parseConditional(cond Expr) Expr {
+	if p.tok != QUESTION {
+		return cond
+	}
+	p.next()
+	x := &Conditional{cond: cond, then: p.parseExpr()}
+	if p.tok != COLON {
+		p.error("colon expected", ":")
+		x.els = p.sync(p.pos)
 		return x
 	}
-	op := p.tok
 	p.next()
-	y := p.parseUnaryExpr()
-	//y := p.parseOperand()
-	if p.tok == RPAREN || p.tok == EOE || p.tok == COMMA {
-		return &binary{x: x, op: op, y: y}
+	x.els = p.parseExpr()
+	return x
+}
+
+// parseBinaryExpr parses expression containing binary operators of precedence prec1 or
+// higher, x is already parsed left operand or nil. Operands of operators with the same
+// precedence are associated to the left.
+func (p *parser) parseBinaryExpr(x Expr, prec1 int) Expr {
+	if x == nil {
+		x = p.parseUnaryExpr()
 	}
-	if prec[op] >= prec[p.tok] {
-		return p.parseExpr(&binary{x: x, op: op, y: y})
-	} else {
-		return &binary{x: x, op: op, y: p.parseExpr(y)}
+	for {
+		op := p.tok
+		oprec := prec[op] // zero for anything which is not a binary operator
+		if oprec < prec1 {
+			return x
+		}
+		p.next()
+		if op == LIKE || op == ILIKE {
+			x = p.parseLike(x, op, oprec+1)
+			continue
+		}
+		prec2 := oprec + 1
+		if rightAssoc(op) {
+			prec2 = oprec
+		}
+		y := p.parseBinaryExpr(nil, prec2)
+		x = &Binary{x: x, op: op, y: y}
 	}
 }
 
+// parseLike parses pattern and optional ESCAPE clause of LIKE or ILIKE operation, pattern
+// given as string literal is compiled at once
+func (p *parser) parseLike(x Expr, op Token, prec1 int) Expr {
+	from, to := p.pos, p.end
+	l := &Like{x: x, op: op, pattern: p.parseBinaryExpr(nil, prec1)}
+	escape := '\\'
+	if p.tok == IDENT && strings.EqualFold(p.lit, "ESCAPE") && p.scanner.src[p.pos] != '{' {
+		p.next()
+		escFrom, escTo := p.pos, p.end
+		l.escape = p.parseBinaryExpr(nil, prec1)
+		lit, ok := l.escape.(*Literal)
+		if !ok {
+			return l
+		}
+		s, ok := lit.value.(string)
+		if !ok {
+			return l
+		}
+		var err error
+		if escape, err = escapeRune(s); err != nil {
+			p.report(p.scanner.errorAt(escFrom, escTo-escFrom, err.Error()))
+			return l
+		}
+	}
+	if lit, ok := l.pattern.(*Literal); ok {
+		if s, ok := lit.value.(string); ok {
+			re, err := likeRegexp(s, escape, op == ILIKE)
+			if err != nil {
+				p.report(p.scanner.errorAt(from, to-from, err.Error()))
+			}
+			l.re = re
+		}
+	}
+	return l
+}
+
 func (p *parser) parseParenExpr() Expr {
 	p.next() // consume opening parenthesis
-	x := p.parseExpr(nil)
+	x := p.parseExpr()
 	if p.tok != RPAREN {
-		panic(p.scanner.newError("no closing parenthesis"))
+		p.error("no closing parenthesis", ")")
+		p.sync(p.pos)
+		if p.tok != RPAREN {
+			return x
+		}
 	}
 	p.next() // consume closing parenthesis
-	return x
+	return p.trail(x)
 }
 
 func (p *parser) parseUnaryExpr() Expr {
+	comments := p.pending
+	p.pending = nil
 	switch p.tok {
 	case ADD, SUB, NOT:
 		op := p.tok
 		p.next()
-		x := p.parseOperand()
-		return &unary{op: op, x: x}
+		// unary operators may be chained, operand includes operators binding tighter
+		x := p.parseBinaryExpr(nil, unaryPrec+1)
+		return lead(&Unary{op: op, x: x}, comments)
 	}
-	return p.parseOperand()
+	return lead(p.parsePrimaryExpr(), comments)
+}
+
+// parsePrimaryExpr parses operand followed by selectors and indexes
+func (p *parser) parsePrimaryExpr() Expr {
+	from := p.pos
+	x := p.parseOperand()
+	for {
+		switch p.tok {
+		case DOT, SAFEDOT:
+			safe := p.tok == SAFEDOT
+			p.next()
+			switch p.tok {
+			case IDENT, IN, LIKE, ILIKE: // keywords are names of fields after dot
+				x = &Selector{x: x, sel: p.lit, safe: safe}
+				to := p.end
+				p.next()
+				if name, ok := dottedName(x); ok && p.tok == LPAREN {
+					x = p.parseCall(name, from, to) // a.b(c) calls function A.B
+					continue
+				}
+				x = p.trail(x)
+			default:
+				p.error("field name expected", "identifier")
+				return p.sync(p.pos)
+			}
+		case LBRACK:
+			p.next()
+			x = &Index{x: x, index: p.parseExpr()}
+			if p.tok != RBRACK {
+				p.error("no closing bracket", "]")
+				p.sync(p.pos)
+				if p.tok != RBRACK {
+					return x
+				}
+			}
+			p.next()
+			x = p.trail(x)
+		default:
+			return x
+		}
+	}
+}
+
+// dottedName returns name a.b.c of function called as a.b.c(), unlike path it rejects ?.
+// and names which need curly braces
+func dottedName(x Expr) (string, bool) {
+	switch n := x.(type) {
+	case *Ident:
+		return n.name, isName(n.name)
+	case *Selector:
+		name, ok := dottedName(n.x)
+		return name + "." + n.sel, ok && !n.safe && isName(n.sel)
+	}
+	return "", false
 }
 
 func (p *parser) parseOperand() Expr {
 	switch p.tok {
 	case IDENT:
-		x := &ident{name: p.lit}
+		x := &Ident{name: p.lit}
+		from, to := p.pos, p.end
+		curly := p.scanner.src[p.pos] == '{' // names in curly braces are never keywords
 		p.next()
-		switch strings.ToUpper(x.name) {
-		case "TRUE":
-			return &literal{value: true}
-		case "FALSE":
-			return &literal{value: false}
-		case "NULL":
-			return &literal{value: nil}
+		if !curly {
+			switch strings.ToUpper(x.name) {
+			case "TRUE":
+				return p.trail(&Literal{value: true})
+			case "FALSE":
+				return p.trail(&Literal{value: false})
+			case "NULL":
+				return p.trail(&Literal{value: nil})
+			}
 		}
 		if p.tok == LPAREN {
-			return p.parseCall(x)
+			return p.parseCall(x.name, from, to)
 		}
-		return x
+		return p.trail(x)
 	case STRING:
-		x := &literal{value: p.lit}
+		x := &Literal{value: p.lit}
 		p.next()
-		return x
+		return p.trail(x)
 	case NUMBER:
 		v, ok := new(big.Rat).SetString(p.lit)
 		if !ok {
-			panic(p.scanner.newError("not a number: " + p.lit))
+			p.error("not a number: " + p.lit)
+			from := p.pos
+			p.next()
+			return p.sync(from)
 		}
-		x := &literal{value: v}
+		x := &Literal{value: v}
 		p.next()
-		return x
+		return p.trail(x)
 	case LPAREN:
 		return p.parseParenExpr()
-	case RPAREN:
-		return nil
+	case LBRACK:
+		return p.parseList()
+	case BAD:
+		p.error("unexpected character: " + p.lit)
+	default:
+		p.error("operand expected", "identifier", "number", "string", "(", "[")
 	}
-	panic(p.scanner.newError("operand expected"))
+	return p.sync(p.pos)
 }
 
-func (p *parser) parseCall(ident *ident) Expr {
+// parseCall parses arguments of function, from and to is source range of its name
+func (p *parser) parseCall(name string, from, to int) Expr {
 	p.next() // consume LPAREN
-	var args []Expr = make([]Expr, 0)
-	for {
-		arg := p.parseExpr(nil)
-		if arg != nil {
-			args = append(args, arg)
+	x := &Call{name: strings.ToUpper(name), args: make([]Expr, 0)}
+	var offsets []int // of arguments
+	if p.tok != RPAREN {
+		for {
+			offsets = append(offsets, p.pos)
+			if p.isLambda() {
+				x.args = append(x.args, p.parseLambda())
+			} else {
+				x.args = append(x.args, p.parseExpr())
+			}
+			if p.tok != COMMA && p.tok != RPAREN {
+				p.error("comma or closing parenthesis expected", ",", ")")
+				p.sync(p.pos)
+			}
+			if p.tok != COMMA {
+				break
+			}
+			p.next()
+		}
+	}
+	if p.tok == RPAREN { // missing only at the end of expression after syntax error
+		p.next()
+	}
+	if x.name == "LET" {
+		p.checkLet(x, offsets, from, to)
+	}
+	return p.trail(x)
+}
+
+// checkLet checks that arguments of LET are pairs of name and value followed by body
+func (p *parser) checkLet(x *Call, offsets []int, from, to int) {
+	if len(x.args) < 3 || len(x.args)%2 == 0 {
+		p.report(p.scanner.errorAt(from, to-from, "LET expects pairs of name and value followed by body"))
+		return
+	}
+	for i := 0; i < len(x.args)-1; i += 2 {
+		if _, ok := x.args[i].(*Ident); !ok {
+			p.report(p.scanner.errorAt(offsets[i], 1, "name expected", "identifier"))
 		}
-		if p.tok != COMMA && p.tok != RPAREN {
-			panic(p.scanner.newError("comma or closing parenthesis expected"))
+	}
+}
+
+func (p *parser) parseList() Expr {
+	p.next() // consume LBRACK
+	x := &List{elems: make([]Expr, 0)}
+	if p.tok != RBRACK {
+		for {
+			x.elems = append(x.elems, p.parseExpr())
+			if p.tok != COMMA && p.tok != RBRACK {
+				p.error("comma or closing bracket expected", ",", "]")
+				p.sync(p.pos)
+			}
+			if p.tok != COMMA {
+				break
+			}
+			p.next()
 		}
-		if p.tok == COMMA {
+	}
+	if p.tok == RBRACK { // missing only at the end of expression after syntax error
+		p.next()
+	}
+	return p.trail(x)
+}
+
+// isLambda returns whether lambda starts at the current token: identifier or list of
+// identifiers in parentheses followed by arrow. Parser state is restored after look ahead.
+// Errors of scanning are ignored, they are reported when the tokens are parsed again.
+func (p *parser) isLambda() bool {
+	saved := *p
+	defer func() {
+		*p = saved
+	}()
+	p.scanner.report = func(*ParseError) {}
+	switch p.tok {
+	case IDENT:
+		p.next()
+		return p.tok == ARROW
+	case LPAREN:
+		p.next()
+		for p.tok != RPAREN {
+			if p.tok != IDENT {
+				return false
+			}
 			p.next()
-		} else if p.tok == RPAREN || p.tok == EOE {
-			break
+			if p.tok == COMMA {
+				p.next()
+			} else if p.tok != RPAREN {
+				return false
+			}
+		}
+		p.next()
+		return p.tok == ARROW
+	}
+	return false
+}
+
+func (p *parser) parseLambda() Expr {
+	comments := p.pending
+	p.pending = nil
+	x := &Lambda{params: make([]string, 0)}
+	if p.tok == IDENT {
+		x.params = append(x.params, p.lit)
+	} else {
+		for p.next(); p.tok == IDENT; p.next() {
+			x.params = append(x.params, p.lit)
+			if p.next(); p.tok != COMMA {
+				break
+			}
 		}
 	}
-	p.next() // consume RPAREN
-	ident.name = strings.ToUpper(ident.name)
-	return &call{ident: ident, args: args}
+	p.next() // consume identifier or closing parenthesis
+	p.next() // consume arrow
+	x.body = p.parseExpr()
+	return lead(x, comments)
 }
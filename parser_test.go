@@ -0,0 +1,376 @@
+package eval
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+// tree returns shape of the expression as s-expression, e.g. (+ a (* b c))
+func tree(e Expr) string {
+	switch x := e.(type) {
+	case *Ident:
+		return x.name
+	case *Literal:
+		if r, ok := x.value.(*big.Rat); ok {
+			return numberString(r)
+		}
+		return fmt.Sprint(x.value)
+	case *Selector:
+		if x.safe {
+			return tree(x.x) + "?." + x.sel
+		}
+		return tree(x.x) + "." + x.sel
+	case *Index:
+		return tree(x.x) + "[" + tree(x.index) + "]"
+	case *Unary:
+		return "(" + repr(x.op) + " " + tree(x.x) + ")"
+	case *Binary:
+		return "(" + repr(x.op) + " " + tree(x.x) + " " + tree(x.y) + ")"
+	case *List:
+		s := "["
+		for i, e := range x.elems {
+			if i > 0 {
+				s += " "
+			}
+			s += tree(e)
+		}
+		return s + "]"
+	case *Like:
+		s := "(" + repr(x.op) + " " + tree(x.x) + " " + tree(x.pattern)
+		if x.escape != nil {
+			s += " " + tree(x.escape)
+		}
+		return s + ")"
+	case *Lambda:
+		return "(-> (" + strings.Join(x.params, " ") + ") " + tree(x.body) + ")"
+	case *Conditional:
+		return "(? " + tree(x.cond) + " " + tree(x.then) + " " + tree(x.els) + ")"
+	case *BadExpr:
+		return "BAD"
+	case *Call:
+		s := "(" + x.name
+		for _, a := range x.args {
+			s += " " + tree(a)
+		}
+		return s + ")"
+	}
+	return fmt.Sprintf("%T", e)
+}
+
+func mustParseTree(t *testing.T, src, expected string) {
+	t.Helper()
+	mustParseDialectTree(t, Standard, src, expected)
+}
+
+func mustParseDialectTree(t *testing.T, d Dialect, src, expected string) {
+	t.Helper()
+	e, err := d.ParseString(src)
+	if err != nil {
+		t.Error("failed to parse:", src, err)
+		return
+	}
+	if s := tree(e); s != expected {
+		t.Error("wrong tree of:", src, "expected:", expected, "actual:", s)
+	}
+}
+
+func TestParseTree(t *testing.T) {
+	tests := []struct {
+		src  string
+		tree string
+	}{
+		{"a", "a"},
+		{"a + b", "(+ a b)"},
+		{"a - b - c", "(- (- a b) c)"},
+		{"a / b / c", "(/ (/ a b) c)"},
+		{"a - b + c", "(+ (- a b) c)"},
+		{"a - b - c * d + e", "(+ (- (- a b) (* c d)) e)"},
+		{"a * b + c * d", "(+ (* a b) (* c d))"},
+		{"a + b * c - d / e", "(- (+ a (* b c)) (/ d e))"},
+		{"a < b == c > d", "(== (< a b) (> c d))"},
+		{"a + b < c * d", "(< (+ a b) (* c d))"},
+		{"a == b != c", "(!= (== a b) c)"},
+		{"a || b && c", "(|| a (&& b c))"},
+		{"a && b || c && d", "(|| (&& a b) (&& c d))"},
+		{"a <> b", "(!= a b)"},
+		{"a ^ b ^ c", "(^ a (^ b c))"},
+		{"a ** -b * c", "(* (^ a (- b)) c)"},
+		{"a % b * c", "(* (% a b) c)"},
+		{"(a + b) * c", "(* (+ a b) c)"},
+		{"a * (b + c)", "(* a (+ b c))"},
+		{"((a))", "a"},
+		{"-a", "(- a)"},
+		{"- -1", "(- (- 1))"},
+		{"--1", "(- (- 1))"},
+		{"+-a", "(+ (- a))"},
+		{"!!x", "(! (! x))"},
+		{"!(!x)", "(! (! x))"},
+		{"-a * b", "(* (- a) b)"},
+		{"-a - -b", "(- (- a) (- b))"},
+		{"!a && b", "(&& (! a) b)"},
+		{"-(a + b)", "(- (+ a b))"},
+		{"f()", "(F)"},
+		{"f(a)", "(F a)"},
+		{"f(a + b, -c) * 2", "(* (F (+ a b) (- c)) 2)"},
+		{"f(g(a, b), (c))", "(F (G a b) c)"},
+		{"true && null", "(&& true <nil>)"},
+		{"a ? b : c", "(? a b c)"},
+		{"a || b ? c + d : -e", "(? (|| a b) (+ c d) (- e))"},
+		{"a ? b : c ? d : e", "(? a b (? c d e))"},
+		{"a ? b ? c : d : e", "(? a (? b c d) e)"},
+		{"(a ? b : c) ? d : e", "(? (? a b c) d e)"},
+		{"(a ? b : c) + d", "(+ (? a b c) d)"},
+		{"f(a ? b : c, d)", "(F (? a b c) d)"},
+		{"a ?? b ?? c", "(?? a (?? b c))"},
+		{"a || b ?? c && d", "(?? (|| a b) (&& c d))"},
+		{"a ?? b ? c : d ?? e", "(? (?? a b) c (?? d e))"},
+		{"a?b:c", "(? a b c)"},
+		{"a?.b.c?.d", "a?.b.c?.d"},
+		{"f(x)?.y ?? -z", "(?? (F x)?.y (- z))"},
+		{"[]", "[]"},
+		{"[a, 1 + 2, [b]]", "[a (+ 1 2) [b]]"},
+		{"a in [1, 2]", "(IN a [1 2])"},
+		{"a + b NOT IN c && d", "(&& (NOT IN (+ a b) c) d)"},
+		{"a not\n  in b", "(NOT IN a b)"},
+		{"not(a) in b", "(IN (NOT a) b)"},
+		{"{not} in {in}", "(IN not in)"},
+		{"a in b == c", "(== (IN a b) c)"},
+		{"innate + notion", "(+ innate notion)"},
+		{"a like 'x%' && b ILIKE c", "(&& (LIKE a x%) (ILIKE b c))"},
+		{"a || b LIKE c || d", "(|| (|| a (LIKE b c)) d)"},
+		{"a LIKE b escape c == d", "(== (LIKE a b c) d)"},
+		{"a LIKE b + c ESCAPE d + e", "(LIKE a (+ b c) (+ d e))"},
+		{"a LIKE {escape}", "(LIKE a escape)"},
+		{"a.b.c", "a.b.c"},
+		{"a . b + {c d}.{e f}", "(+ a.b c d.e f)"},
+		{"-a.b * f(x).y", "(* (- a.b) (F x).y)"},
+		{"a.in + a.true + a.like", "(+ (+ a.in a.true) a.like)"},
+		{"a.b(c) + x.y.z()", "(+ (A.B c) (X.Y.Z))"},
+		{"a.b(c).d", "(A.B c).d"},
+		{"1.5", "1.5"},
+		{"a[0]", "a[0]"},
+		{"a.b[-1].c['d'][e + 1]", "a.b[(- 1)].c[d][(+ e 1)]"},
+		{"[1, 2][0] in x[y]", "(IN [1 2][0] x[y])"},
+		{"-a[0] * 2", "(* (- a[0]) 2)"},
+		{"map(l, x -> x.a > 1)", "(MAP l (-> (x) (> x.a 1)))"},
+		{"reduce(l, 0, (acc, x) -> acc + x)", "(REDUCE l 0 (-> (acc x) (+ acc x)))"},
+		{"f(() -> 1, (x) -> x ? a : b, (a) + 1)", "(F (-> () 1) (-> (x) (? x a b)) (+ a 1))"},
+		{"f(x -> g(y -> x + y))", "(F (-> (x) (G (-> (y) (+ x y)))))"},
+	}
+	for _, test := range tests {
+		mustParseTree(t, test.src, test.tree)
+	}
+}
+
+func TestParseOperatorCombinations(t *testing.T) {
+	var ops []Token
+	for op := range prec {
+		ops = append(ops, op)
+	}
+	for _, op1 := range ops {
+		for _, op2 := range ops {
+			src := "a " + repr(op1) + " b " + repr(op2) + " c"
+			expected := "(" + repr(op2) + " (" + repr(op1) + " a b) c)"
+			if prec[op2] > prec[op1] || op1 == op2 && rightAssoc(op1) {
+				expected = "(" + repr(op1) + " a (" + repr(op2) + " b c))"
+			}
+			mustParseDialectTree(t, Salesforce, src, expected)
+		}
+		for _, u := range []Token{ADD, SUB, NOT} {
+			expected := "(" + repr(op1) + " (" + repr(u) + " a) b)"
+			if prec[op1] > unaryPrec {
+				expected = "(" + repr(u) + " (" + repr(op1) + " a b))"
+			}
+			mustParseDialectTree(t, Salesforce, repr(u)+"a "+repr(op1)+" b", expected)
+			mustParseDialectTree(t, Salesforce, "a "+repr(op1)+" "+repr(u)+"b", "("+repr(op1)+" a ("+repr(u)+" b))")
+		}
+	}
+}
+
+func TestParseSalesforce(t *testing.T) {
+	tests := []struct {
+		src  string
+		tree string
+	}{
+		{"a = b", "(== a b)"},
+		{"a == b", "(== a b)"},
+		{"a <> b && c != d", "(&& (!= a b) (!= c d))"},
+		{"a & b + c & d", "(& (+ (& a b) c) d)"},
+		{"-2^2", "(- (^ 2 2))"},
+		{"2^-1", "(^ 2 (- 1))"},
+		{"a * b ^ c", "(* a (^ b c))"},
+		{"$User.Id = OwnerId", "(== $User.Id OwnerId)"},
+		{"a <= b = c > d", "(== (<= a b) (> c d))"},
+	}
+	for _, test := range tests {
+		mustParseDialectTree(t, Salesforce, test.src, test.tree)
+	}
+	for _, src := range []string{"a = b", "a & b", "$User.Id"} {
+		if _, err := ParseString(src); err == nil {
+			t.Error("Salesforce syntax accepted in standard dialect:", src)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		src     string
+		message string
+		column  int
+	}{
+		{"a b", "operator expected", 3},
+		{"2+2)", "no matching opening parenthesis", 4},
+		{"f(a 3)", "comma or closing parenthesis expected", 5},
+		{"f(a,)", "operand expected", 5},
+		{"(a", "no closing parenthesis", 3},
+		{"a +", "operand expected", 4},
+		{"a, b", "unexpected comma", 2},
+		{"a # b", "unexpected character: #", 3},
+		{"a ? b", "colon expected", 6},
+		{"a : b", "unexpected colon", 3},
+		{"a + ? b : c", "operand expected", 5},
+		{"[a b]", "comma or closing bracket expected", 4},
+		{"a]", "no matching opening bracket", 2},
+		{"a in", "operand expected", 5},
+		{"not in b", "operand expected", 1},
+		{"a LIKE 'x!' ESCAPE '!'", "pattern ends with escape character", 8},
+		{"a LIKE b ESCAPE 'ab'", "escape must be a single character", 17},
+		{"a LIKE b {escape} c", "operator expected", 10},
+		{"a.", "field name expected", 3},
+		{"a.1", "field name expected", 3},
+		{"a?.b(c)", "operator expected", 5},
+		{"f(x).y(z)", "operator expected", 7},
+		{"a[1", "no closing bracket", 4},
+		{"a[]", "operand expected", 3},
+		{"x -> x", "lambda allowed only as function argument", 3},
+		{"f(1 + x -> x)", "comma or closing parenthesis expected", 9},
+		{"f((x, 1) -> x)", "no closing parenthesis", 5},
+		{"f(x -> )", "operand expected", 8},
+		{"let(a, 1)", "LET expects pairs of name and value followed by body", 1},
+		{"2 * LET(a, 1, b, 2)", "LET expects pairs of name and value followed by body", 5},
+		{"LET(a, 1, 'b', 2, a)", "name expected", 11},
+		{"f(a '", "unterminated string", 5},
+		{"f(a /* x", "unterminated comment", 5},
+		{"f((a /* x", "unterminated comment", 6},
+	}
+	for _, test := range tests {
+		e, err := ParseString(test.src)
+		pe, ok := err.(*ParseError)
+		if !ok || e != nil {
+			t.Error("parse error expected:", test.src, e, err)
+			continue
+		}
+		if !strings.Contains(pe.Message, test.message) || pe.Column != test.column {
+			t.Error("wrong error for:", test.src, "expected:", test.message, test.column, "actual:", pe.Message, pe.Column)
+		}
+	}
+}
+
+func TestParseAll(t *testing.T) {
+	tests := []struct {
+		src     string
+		tree    string
+		columns []int
+	}{
+		{"f(a, b)", "(F a b)", nil},
+		{"f(a +, g(1 2), c # d) + h(", "(+ (F (+ a BAD) (G 1) c) (H BAD))", []int{6, 12, 18, 27}},
+		{"(a, b) * 2", "(* a 2)", []int{3, 6}},
+		{"a # b", "a", []int{3}},
+		{"a b + c) d", "a", []int{3, 8, 10}},
+		{"", "BAD", []int{1}},
+		{"1 + \xff2", "(+ 1 2)", []int{5}},
+		{"[a + , f(b c), d e] in x", "(IN [(+ a BAD) (F b) d] x)", []int{6, 12, 18}},
+		{"f(a ? b, c ? d e)", "(F (? a b BAD) (? c d BAD))", []int{8, 16}},
+	}
+	for _, test := range tests {
+		e, errs := ParseAll([]byte(test.src))
+		if e == nil {
+			t.Error("no expression returned:", test.src)
+			continue
+		}
+		if s := tree(e); s != test.tree {
+			t.Error("wrong tree of:", test.src, "expected:", test.tree, "actual:", s)
+		}
+		var columns []int
+		for _, err := range errs {
+			columns = append(columns, err.Column)
+		}
+		if fmt.Sprint(columns) != fmt.Sprint(test.columns) {
+			t.Error("wrong errors of:", test.src, "expected at:", test.columns, "actual:", errs)
+		}
+		if _, err := e.Eval(test_context); strings.Contains(test.tree, "BAD") && err == nil {
+			t.Error("evaluation of partial expression should fail:", test.src)
+		}
+	}
+}
+
+func TestParseScript(t *testing.T) {
+	tests := []struct {
+		src  string
+		tree string
+	}{
+		{"", ""},
+		{"a", "a"},
+		{"a; b", "a | b"},
+		{"x := 1 + 2\ny := x * 2; y", "x := (+ 1 2) | y := (* x 2) | y"},
+		{"\n\n  x := f(a,\n b)\n\n  x + [1,\n2][0]\n", "x := (F a b) | (+ x [1 2][0])"},
+		{"x := a +\n b // comment\n-x", "x := (+ a b) | (- x)"},
+		{"x := a\n  ? b\n  : c", "x := a | BAD"},
+		{"x := (a\n  ? b\n  : c)", "x := (? a b c)"},
+		{"a not\nin b", "(NOT IN a b)"},
+		{"y := MAP(l, x ->\n x + 1)\n;;y", "y := (MAP l (-> (x) (+ x 1))) | y"},
+	}
+	for _, test := range tests {
+		s, err := ParseScript([]byte(test.src))
+		if err != nil {
+			if !strings.Contains(test.tree, "BAD") {
+				t.Error("failed to parse:", test.src, err)
+			}
+			continue
+		}
+		var stmts []string
+		for _, stmt := range s.Stmts() {
+			if a, ok := stmt.(*Assign); ok {
+				stmts = append(stmts, a.Name()+" := "+tree(a.X()))
+			} else {
+				stmts = append(stmts, tree(stmt))
+			}
+		}
+		if r := strings.Join(stmts, " | "); r != test.tree {
+			t.Error("wrong script:", test.src, "expected:", test.tree, "actual:", r)
+		}
+	}
+	errors := []struct {
+		src     string
+		message string
+		line    int
+	}{
+		{"a b", "semicolon or new line expected", 1},
+		{"x := 1\na + 1 := 2", "assignment to a + 1", 2},
+		{"x := 1\nx := ", "operand expected", 2},
+		{"x := f(1,\n2", "comma or closing parenthesis expected", 2},
+		{"f(a '", "unterminated string", 1},
+		{"x := 1\ny := f(a /* z", "unterminated comment", 2},
+	}
+	for _, test := range errors {
+		_, err := ParseScript([]byte(test.src))
+		pe, ok := err.(*ParseError)
+		if !ok || !strings.Contains(pe.Message, test.message) || pe.Line != test.line {
+			t.Error("wrong error for:", test.src, "expected:", test.message, test.line, "actual:", err)
+		}
+	}
+	// every prefix of valid script is parsed or rejected with syntax error
+	src := "x := map(l, (a, b) -> a.b[0] ?? {c d}) // done\ny := f(x, 'a\\'b' /* c */, -1.5e3)\nx"
+	for i := range src {
+		s, err := ParseScript([]byte(src[:i]))
+		if _, ok := err.(*ParseError); !ok && (err != nil || s == nil) {
+			t.Error("syntax error expected:", src[:i], err)
+		}
+		e, err := ParseString(src[:i])
+		if _, ok := err.(*ParseError); !ok && (err != nil || e == nil) {
+			t.Error("syntax error expected:", src[:i], err)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package eval
+
+import (
+	"database/sql/driver"
+	"encoding"
+	"math/big"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Record returns Values resolving names as fields of v. Records are maps with string keys,
+// structs and pointers to them, nested records are resolved by dotted names like
+// Account.Owner.Name. Struct fields are matched by eval tag, json tag, name and name
+// ignoring case, in this order. Missing keys of maps and nil intermediate records give
+// null, like in Salesforce cross-object formulas. Values of fields are converted like
+// other Go values except floats, which are converted by their shortest decimal
+// representation, so that 0.1 decoded from JSON is 0.1.
+func Record(v interface{}) Values {
+	return func(name string) (interface{}, bool) {
+		x := v
+		for i, n := range strings.Split(name, ".") {
+			if x == nil {
+				return nil, true
+			}
+			if i == 0 && !hasField(x, n) {
+				return nil, false // may be resolved by other Values
+			}
+			f, ok := field(x, n)
+			if !ok {
+				return nil, false
+			}
+			x = f
+		}
+		return x, true
+	}
+}
+
+// isRecord returns whether fields of v can be selected
+func isRecord(v interface{}) bool {
+	switch v.(type) {
+	case time.Time, driver.Valuer, encoding.TextMarshaler:
+		return false // values converted by normalize
+	}
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	switch rv.Kind() {
+	case reflect.Map:
+		return rv.Type().Key().Kind() == reflect.String
+	case reflect.Struct:
+		return rv.Type() != reflect.TypeOf(big.Rat{})
+	}
+	return false
+}
+
+// field returns value of field of record x, false if x is not a record or struct has no
+// such field
+func field(x interface{}, name string) (interface{}, bool) {
+	if !isRecord(x) {
+		return nil, false
+	}
+	rv := reflect.Indirect(reflect.ValueOf(x))
+	if rv.Kind() == reflect.Map {
+		f := rv.MapIndex(reflect.ValueOf(name).Convert(rv.Type().Key()))
+		if !f.IsValid() {
+			return nil, true
+		}
+		return fieldValue(f), true
+	}
+	sf, ok := structField(rv.Type(), name)
+	if !ok {
+		return nil, false
+	}
+	f, err := rv.FieldByIndexErr(sf.Index)
+	if err != nil {
+		return nil, true // nil embedded pointer
+	}
+	if !f.CanInterface() {
+		return nil, false // promoted from unexported embedded struct
+	}
+	return fieldValue(f), true
+}
+
+// hasField returns whether record x has key or field name
+func hasField(x interface{}, name string) bool {
+	if !isRecord(x) {
+		return false
+	}
+	rv := reflect.Indirect(reflect.ValueOf(x))
+	if rv.Kind() == reflect.Map {
+		return rv.MapIndex(reflect.ValueOf(name).Convert(rv.Type().Key())).IsValid()
+	}
+	_, ok := structField(rv.Type(), name)
+	return ok
+}
+
+func structField(t reflect.Type, name string) (reflect.StructField, bool) {
+	fields := reflect.VisibleFields(t)
+	match := []func(f reflect.StructField) bool{
+		func(f reflect.StructField) bool { return tagName(f, "eval") == name },
+		func(f reflect.StructField) bool { return tagName(f, "json") == name },
+		func(f reflect.StructField) bool { return f.Name == name },
+		func(f reflect.StructField) bool { return strings.EqualFold(f.Name, name) },
+	}
+	for _, m := range match {
+		for _, f := range fields {
+			if f.IsExported() && !f.Anonymous && tagName(f, "eval") != "-" && m(f) {
+				return f, true
+			}
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// tagName returns name given by the tag of struct field
+func tagName(f reflect.StructField, key string) string {
+	name, _, _ := strings.Cut(f.Tag.Get(key), ",")
+	return name
+}
+
+// fieldValue returns value of field, nil pointer is null
+func fieldValue(f reflect.Value) interface{} {
+	e := f
+	for e.Kind() == reflect.Ptr || e.Kind() == reflect.Interface {
+		if e.IsNil() {
+			return nil
+		}
+		e = e.Elem()
+	}
+	if e.Kind() == reflect.Float32 || e.Kind() == reflect.Float64 {
+		if r, ok := shortestRat(e.Float(), e.Type().Bits()); ok {
+			return r
+		}
+	}
+	return f.Interface()
+}
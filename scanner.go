@@ -1,6 +1,7 @@
 package eval
 
 import (
+	"strings"
 	"unicode/utf8"
 )
 
@@ -10,13 +11,20 @@ type scanner struct {
 	ch       rune // current character
 	offset   int  // character offset
 	nxOffset int  // next offset
-	// line and char counters for error location
-	linePos int // current line for error reporting
-	charPos int // current character for error reporting
+	// start of the last scanned token, the token ends at offset
+	tokOffset int
+	dialect   Dialect
+	report    func(*ParseError) // error handler, scanning continues if it returns
+	comments  []Comment         // comments found before the last scanned token
+	// scripts only: new line after operand not nested in parentheses or brackets ends
+	// statement and is scanned as SEMICOLON
+	script bool
+	depth  int  // nesting of parentheses and brackets
+	semi   bool // new line is SEMICOLON
 }
 
-func newScanner(src []byte) scanner {
-	s := scanner{src: src, ch: ' ', offset: 0, nxOffset: 0, linePos: 1, charPos: 0}
+func newScanner(src []byte, dialect Dialect, report func(*ParseError)) scanner {
+	s := scanner{src: src, ch: ' ', offset: 0, nxOffset: 0, dialect: dialect, report: report}
 	s.next()
 	return s
 }
@@ -24,34 +32,62 @@ func newScanner(src []byte) scanner {
 func (s *scanner) next() {
 	if s.nxOffset < len(s.src) {
 		s.offset = s.nxOffset
-		if s.ch == '\n' {
-			s.linePos += 1
-			s.charPos = 0
-		}
 		r, w := utf8.DecodeRune(s.src[s.nxOffset:])
 		if r == utf8.RuneError && w == 1 {
-			panic(s.newError("illegal utf-8 character"))
+			// report and skip illegal byte
+			s.report(s.errorAt(s.offset, 1, "illegal utf-8 character"))
+			s.nxOffset += w
+			s.next()
+			return
 		}
 		s.nxOffset += w
 		s.ch = r
-		s.charPos += 1
 	} else {
 		s.offset = len(s.src)
 		s.ch = -1 // end of expression
 	}
 }
 
-func (s *scanner) newError(msg string) ScannerError {
-	return ScannerError{message: msg, line: s.linePos, position: s.charPos}
+// errorAt returns error for the source range starting at offset, line and column are
+// calculated from the source
+func (s *scanner) errorAt(offset, length int, msg string, expected ...string) *ParseError {
+	return newParseError(s.src, offset, length, msg, expected)
+}
+
+func (s *scanner) scan() (Token, string) {
+	tok, lit := s.scanToken()
+	if s.script {
+		switch tok {
+		case LPAREN, LBRACK:
+			s.depth++
+		case RPAREN, RBRACK:
+			if s.depth > 0 {
+				s.depth--
+			}
+		}
+		switch tok {
+		case IDENT, STRING, NUMBER, RPAREN, RBRACK:
+			s.semi = s.depth == 0
+		default:
+			s.semi = false
+		}
+	}
+	return tok, lit
 }
 
-func (s *scanner) scan() (token, string) {
-	s.skipWhitespace()
+func (s *scanner) scanToken() (Token, string) {
+	s.comments = nil
+	s.skipWhitespace(s.semi)
+	s.tokOffset = s.offset
+	if s.semi && s.ch == '\n' {
+		s.next()
+		return SEMICOLON, "\n"
+	}
 	switch {
 	case s.ch == '{':
 		t, l := s.scanCurlyIdentifier()
 		return t, l
-	case isLetter(s.ch):
+	case isLetter(s.ch) || s.ch == '$' && s.dialect == Salesforce:
 		t, l := s.scanIdentifier()
 		return t, l
 	case '0' <= s.ch && s.ch <= '9':
@@ -64,6 +100,9 @@ func (s *scanner) scan() (token, string) {
 		ch := s.ch
 		s.next() // look ahead
 		for _, ops := range tokens {
+			if ops.salesforce && s.dialect != Salesforce {
+				continue
+			}
 			if ch == ops.seq[0] && (len(ops.seq) == 1 || s.ch == ops.seq[1]) {
 				if len(ops.seq) == 2 {
 					s.next()
@@ -72,13 +111,59 @@ func (s *scanner) scan() (token, string) {
 			}
 		}
 	}
-	return BAD, string(s.ch)
+	return BAD, string(s.src[s.tokOffset:s.offset])
+}
+
+// peek returns byte following the current character or 0 at the end of source
+func (s *scanner) peek() byte {
+	if s.nxOffset < len(s.src) {
+		return s.src[s.nxOffset]
+	}
+	return 0
+}
+
+// skipWhitespace skips white space and comments, comments are collected. It stops at new
+// line if newline is true.
+func (s *scanner) skipWhitespace(newline bool) {
+	for {
+		switch {
+		case s.ch == '\n' && newline:
+			return
+		case s.ch == ' ' || s.ch == '\t' || s.ch == '\n' || s.ch == '\r':
+			s.next()
+		case s.ch == '/' && (s.peek() == '/' || s.peek() == '*'):
+			s.scanComment()
+		default:
+			return
+		}
+	}
 }
 
-func (s *scanner) skipWhitespace() {
-	for s.ch == ' ' || s.ch == '\t' || s.ch == '\n' || s.ch == '\r' {
+func (s *scanner) scanComment() {
+	offs := s.offset
+	s.next() // eat /
+	if s.ch == '/' {
+		for s.ch != '\n' && s.ch != -1 {
+			s.next()
+		}
+		text := strings.TrimRight(string(s.src[offs:s.offset]), "\r")
+		s.comments = append(s.comments, Comment{Text: text})
+		return
+	}
+	s.next() // eat *
+	for {
+		if s.ch == -1 {
+			s.report(s.errorAt(offs, 2, "unterminated comment"))
+			break
+		}
+		if s.ch == '*' && s.peek() == '/' {
+			s.next()
+			s.next()
+			break
+		}
 		s.next()
 	}
+	s.comments = append(s.comments, Comment{Text: string(s.src[offs:s.offset])})
 }
 
 func isLetter(ch rune) bool {
@@ -89,25 +174,57 @@ func isDigit(ch rune) bool {
 	return '0' <= ch && ch <= '9'
 }
 
-func (s *scanner) scanIdentifier() (token, string) {
+func (s *scanner) scanIdentifier() (Token, string) {
 	offs := s.offset
-	for isLetter(s.ch) || isDigit(s.ch) || s.ch == '_' || s.ch == '.' {
+	if s.ch == '$' {
 		s.next()
 	}
-	return IDENT, string(s.src[offs:s.offset])
+	for isLetter(s.ch) || isDigit(s.ch) || s.ch == '_' {
+		s.next()
+	}
+	name := string(s.src[offs:s.offset])
+	switch strings.ToUpper(name) {
+	case "IN":
+		return IN, name
+	case "LIKE":
+		return LIKE, name
+	case "ILIKE":
+		return ILIKE, name
+	case "NOT":
+		// NOT followed by IN is an operator, otherwise identifier like in NOT(x)
+		saved := *s
+		s.skipWhitespace(false)
+		if isLetter(s.ch) {
+			if tok, _ := s.scanIdentifier(); tok == IN {
+				return NOTIN, string(s.src[offs:s.offset])
+			}
+		}
+		*s = saved
+	}
+	return IDENT, name
 }
 
-func (s *scanner) scanCurlyIdentifier() (token, string) {
-	s.next() // eat opening {
+// scanCurlyIdentifier scans name in curly braces, \} and \\ stand for } and \ in the name
+func (s *scanner) scanCurlyIdentifier() (Token, string) {
 	offs := s.offset
-	for prev := ' '; s.ch != '}' && prev != '\\'; s.next() {
-		prev = s.ch
+	s.next() // eat opening {
+	var b strings.Builder
+	for s.ch != '}' {
+		if s.ch == -1 {
+			s.report(s.errorAt(offs, 1, "unterminated identifier"))
+			return IDENT, b.String()
+		}
+		if s.ch == '\\' && (s.peek() == '}' || s.peek() == '\\') {
+			s.next()
+		}
+		b.WriteRune(s.ch)
+		s.next()
 	}
 	s.next() // eat closing
-	return IDENT, string(s.src[offs : s.offset-1])
+	return IDENT, b.String()
 }
 
-func (s *scanner) scanNumber() (token, string) {
+func (s *scanner) scanNumber() (Token, string) {
 	offs := s.offset
 	for isDigit(s.ch) {
 		s.next()
@@ -130,16 +247,69 @@ func (s *scanner) scanNumber() (token, string) {
 	return NUMBER, string(s.src[offs:s.offset])
 }
 
-func (s *scanner) scanString() (token, string) {
+func (s *scanner) scanString() (Token, string) {
+	offs := s.offset
 	delim := s.ch
 	s.next() // eat delim
-	offs := s.offset
+	var b strings.Builder
 	for s.ch != delim {
-		if s.ch == '\\' {
+		switch s.ch {
+		case -1:
+			s.report(s.errorAt(offs, 1, "unterminated string"))
+			return STRING, b.String()
+		case '\\':
+			s.scanEscape(&b)
+		default:
+			b.WriteRune(s.ch)
 			s.next()
 		}
-		s.next()
 	}
 	s.next() // eat delim
-	return STRING, string(s.src[offs : s.offset-1])
+	return STRING, b.String()
+}
+
+// scanEscape decodes escape sequence: \n, \t, \r, \\, \', \" or \uXXXX
+func (s *scanner) scanEscape(b *strings.Builder) {
+	offs := s.offset
+	s.next() // eat backslash
+	switch s.ch {
+	case 'n':
+		b.WriteByte('\n')
+	case 't':
+		b.WriteByte('\t')
+	case 'r':
+		b.WriteByte('\r')
+	case '\\', '\'', '"':
+		b.WriteRune(s.ch)
+	case 'u':
+		var r rune
+		for i := 0; i < 4; i++ {
+			s.next()
+			d, ok := hexDigit(s.ch)
+			if !ok {
+				s.report(s.errorAt(offs, s.offset-offs, "illegal unicode escape sequence"))
+				return
+			}
+			r = r*16 + d
+		}
+		b.WriteRune(r)
+	case -1:
+		return // reported as unterminated string
+	default:
+		s.report(s.errorAt(offs, s.nxOffset-offs, "unknown escape sequence"))
+		b.WriteRune(s.ch)
+	}
+	s.next()
+}
+
+func hexDigit(ch rune) (rune, bool) {
+	switch {
+	case '0' <= ch && ch <= '9':
+		return ch - '0', true
+	case 'a' <= ch && ch <= 'f':
+		return ch - 'a' + 10, true
+	case 'A' <= ch && ch <= 'F':
+		return ch - 'A' + 10, true
+	}
+	return 0, false
 }
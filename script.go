@@ -0,0 +1,63 @@
+package eval
+
+// Script is sequence of statements separated by semicolons or new lines, see ParseScript.
+// Statement is assignment name := expression or expression. Value of the script is value
+// of its last statement if it is an expression, null otherwise.
+type Script struct {
+	commentSet
+	stmts []Expr
+}
+
+// Stmts returns statements of the script, assignments are *Assign
+func (e *Script) Stmts() []Expr {
+	return e.stmts
+}
+
+// Eval evaluates statements in order. Assigned names shadow values of context in the
+// following statements, they are not visible outside of the script.
+func (e *Script) Eval(context Context) (interface{}, error) {
+	c := context.cast().with(make(map[string]interface{}))
+	var v interface{}
+	for _, stmt := range e.stmts {
+		x, err := stmt.Eval(c)
+		if err != nil {
+			return nil, err
+		}
+		if a, ok := stmt.(*Assign); ok {
+			c.scope.values[a.name] = x
+			x = nil
+		}
+		v = x
+	}
+	return v, nil
+}
+
+func (e *Script) String() string {
+	return Format(e)
+}
+
+// Assign is assignment name := x, it is statement of script
+type Assign struct {
+	commentSet
+	name string
+	x    Expr
+}
+
+// Name returns assigned name
+func (e *Assign) Name() string {
+	return e.name
+}
+
+// X returns assigned expression
+func (e *Assign) X() Expr {
+	return e.x
+}
+
+// Eval returns value of assigned expression, it is assigned by Script
+func (e *Assign) Eval(context Context) (interface{}, error) {
+	return e.x.Eval(context)
+}
+
+func (e *Assign) String() string {
+	return Format(e)
+}
@@ -0,0 +1,193 @@
+package eval
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// Escape selects escaping of values inserted into rendered template
+type Escape int
+
+const (
+	EscapeNone Escape = iota // values are inserted as they are
+	EscapeHTML               // values are escaped for HTML text and attribute values
+	EscapeJSON               // values are escaped for JSON strings, quotes are not added
+)
+
+// Template is text with placeholders {! expr } or ${ expr } which are replaced by values
+// of the expressions, see ParseTemplate
+type Template struct {
+	src   string
+	parts []templatePart
+}
+
+// templatePart is literal text or placeholder
+type templatePart struct {
+	text     string
+	expr     Expr // nil for literal text
+	from, to int  // source range of placeholder including braces
+}
+
+// ParseTemplate parses text containing placeholders {! expr } in style of Salesforce merge
+// fields or ${ expr }. Placeholder ends at } which is not in string or in name in curly
+// braces, like in ${ {first name} + '}' }. Placeholder preceded by backslash like \${ is
+// literal text. Syntax errors are returned as *ParseError positioned in the text.
+func ParseTemplate(src string) (*Template, error) {
+	return Standard.ParseTemplate(src)
+}
+
+func parseTemplate(src string, dialect Dialect) (*Template, error) {
+	t := &Template{src: src}
+	var text strings.Builder
+	for i := 0; i < len(src); {
+		switch {
+		case src[i] == '\\' && isPlaceholder(src, i+1):
+			text.WriteString(src[i+1 : i+3])
+			i += 3
+		case isPlaceholder(src, i):
+			end, err := placeholderEnd(src, i+2, dialect)
+			if err != nil {
+				return nil, err
+			}
+			x, err := dialect.Parse([]byte(src[i+2 : end]))
+			if err != nil {
+				if pe, ok := err.(*ParseError); ok {
+					return nil, newParseError([]byte(src), i+2+pe.Offset, pe.Length, pe.Message, pe.Expected)
+				}
+				return nil, err
+			}
+			if text.Len() > 0 {
+				t.parts = append(t.parts, templatePart{text: text.String()})
+				text.Reset()
+			}
+			t.parts = append(t.parts, templatePart{expr: x, from: i, to: end + 1})
+			i = end + 1
+		default:
+			text.WriteByte(src[i])
+			i++
+		}
+	}
+	if text.Len() > 0 {
+		t.parts = append(t.parts, templatePart{text: text.String()})
+	}
+	return t, nil
+}
+
+// isPlaceholder returns whether placeholder starts at offset i
+func isPlaceholder(src string, i int) bool {
+	return i+1 < len(src) && (src[i] == '{' && src[i+1] == '!' || src[i] == '$' && src[i+1] == '{')
+}
+
+// placeholderEnd returns offset of closing brace of placeholder whose expression starts
+// at offset from. The expression is scanned until } which is scanned as illegal character.
+func placeholderEnd(src string, from int, dialect Dialect) (int, error) {
+	var first *ParseError
+	s := newScanner([]byte(src[from:]), dialect, func(err *ParseError) {
+		if first == nil {
+			first = err
+		}
+	})
+	for {
+		tok, lit := s.scan()
+		switch {
+		case tok == BAD && lit == "}":
+			return from + s.tokOffset, nil
+		case tok == EOE && first != nil:
+			return 0, newParseError([]byte(src), from+first.Offset, first.Length, first.Message, first.Expected)
+		case tok == EOE:
+			return 0, newParseError([]byte(src), from-2, 2, "unterminated placeholder", []string{"}"})
+		}
+	}
+}
+
+// Exprs returns expressions of placeholders in order of their occurrence
+func (t *Template) Exprs() []Expr {
+	var exprs []Expr
+	for _, p := range t.parts {
+		if p.expr != nil {
+			exprs = append(exprs, p.expr)
+		}
+	}
+	return exprs
+}
+
+// Render returns text with placeholders replaced by values of their expressions escaped
+// by escape. Null is replaced by empty text, lists by their elements separated by commas.
+// Error of evaluation is returned as *TemplateError.
+func (t *Template) Render(context Context, escape Escape) (string, error) {
+	var b strings.Builder
+	for _, p := range t.parts {
+		if p.expr == nil {
+			b.WriteString(p.text)
+			continue
+		}
+		v, err := p.expr.Eval(context)
+		var s string
+		if err == nil {
+			s, err = valueText(v)
+		}
+		if err != nil {
+			line, column := position([]byte(t.src), p.from)
+			return "", &TemplateError{Placeholder: t.src[p.from:p.to], Line: line, Column: column, Offset: p.from, Err: err}
+		}
+		b.WriteString(escapeText(s, escape))
+	}
+	return b.String(), nil
+}
+
+func (t *Template) String() string {
+	return t.src
+}
+
+// valueText returns text inserted into template for value v
+func valueText(v interface{}) (string, error) {
+	switch x := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return x, nil
+	case *big.Rat:
+		return numberString(x), nil
+	case bool:
+		if x {
+			return "true", nil
+		}
+		return "false", nil
+	case time.Time:
+		if IsDate(x) {
+			return x.Format("2006-01-02"), nil
+		}
+		return x.Format(ISO8601), nil
+	case []interface{}:
+		s := make([]string, len(x))
+		for i, e := range x {
+			var err error
+			if s[i], err = valueText(e); err != nil {
+				return "", err
+			}
+		}
+		return strings.Join(s, ", "), nil
+	}
+	return "", errors.New("not a text:" + fmt.Sprint(v))
+}
+
+func escapeText(s string, escape Escape) string {
+	switch escape {
+	case EscapeHTML:
+		return html.EscapeString(s)
+	case EscapeJSON:
+		var b bytes.Buffer
+		enc := json.NewEncoder(&b)
+		enc.SetEscapeHTML(false)
+		enc.Encode(s) // never fails for string
+		// without quotes and new line added by Encode
+		return string(b.Bytes()[1 : b.Len()-2])
+	}
+	return s
+}
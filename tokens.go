@@ -1,9 +1,10 @@
 package eval
 
-type token int
+// Token is lexical token of the expression, operators of Unary and Binary nodes are tokens
+type Token int
 
 const (
-	EOE token = iota
+	EOE Token = iota
 	BAD
 	IDENT
 	STRING
@@ -25,33 +26,94 @@ const (
 	GTE
 	AND
 	OR
+	CONCAT
+	POW
+	QUESTION
+	COLON
+	LBRACK
+	RBRACK
+	IN
+	NOTIN
+	LIKE
+	ILIKE
+	DOT
+	ARROW
+	SEMICOLON
+	ASSIGN
+	MOD
+	COALESCE
+	SAFEDOT
 )
 
 // sequence is important!
 var tokens = []struct {
-	tok token
-	seq []rune
+	tok        Token
+	seq        []rune
+	salesforce bool // accepted in Salesforce dialect only
 }{
-	{COMMA, []rune{','}},
-	{LPAREN, []rune{'('}},
-	{RPAREN, []rune{')'}},
-	{ADD, []rune{'+'}},
-	{SUB, []rune{'-'}},
-	{MUL, []rune{'*'}},
-	{DIV, []rune{'/'}},
-	{EQ, []rune{'=', '='}},
-	{NEQ, []rune{'!', '='}},
-	{NOT, []rune{'!'}},
-	{NEQ, []rune{'<', '>'}},
-	{LTE, []rune{'<', '='}},
-	{LT, []rune{'<'}},
-	{GTE, []rune{'>', '='}},
-	{GT, []rune{'>'}},
-	{AND, []rune{'&', '&'}},
-	{OR, []rune{'|', '|'}},
+	{COMMA, []rune{','}, false},
+	{LPAREN, []rune{'('}, false},
+	{RPAREN, []rune{')'}, false},
+	{ADD, []rune{'+'}, false},
+	{ARROW, []rune{'-', '>'}, false},
+	{SUB, []rune{'-'}, false},
+	{POW, []rune{'^'}, false},
+	{POW, []rune{'*', '*'}, false},
+	{MUL, []rune{'*'}, false},
+	{DIV, []rune{'/'}, false},
+	{MOD, []rune{'%'}, false},
+	{EQ, []rune{'=', '='}, false},
+	{EQ, []rune{'='}, true},
+	{NEQ, []rune{'!', '='}, false},
+	{NOT, []rune{'!'}, false},
+	{NEQ, []rune{'<', '>'}, false},
+	{LTE, []rune{'<', '='}, false},
+	{LT, []rune{'<'}, false},
+	{GTE, []rune{'>', '='}, false},
+	{GT, []rune{'>'}, false},
+	{AND, []rune{'&', '&'}, false},
+	{CONCAT, []rune{'&'}, true},
+	{OR, []rune{'|', '|'}, false},
+	{COALESCE, []rune{'?', '?'}, false},
+	{SAFEDOT, []rune{'?', '.'}, false},
+	{QUESTION, []rune{'?'}, false},
+	{ASSIGN, []rune{':', '='}, false},
+	{COLON, []rune{':'}, false},
+	{SEMICOLON, []rune{';'}, false},
+	{LBRACK, []rune{'['}, false},
+	{RBRACK, []rune{']'}, false},
+	{DOT, []rune{'.'}, false},
 }
 
-func repr(tok token) string {
+func (tok Token) String() string {
+	switch tok {
+	case EOE:
+		return "end of expression"
+	case BAD:
+		return "illegal character"
+	case IDENT:
+		return "identifier"
+	case STRING:
+		return "string"
+	case NUMBER:
+		return "number"
+	case BOOL:
+		return "boolean"
+	}
+	return repr(tok)
+}
+
+func repr(tok Token) string {
+	switch tok { // operators written as words are scanned as identifiers
+	case IN:
+		return "IN"
+	case NOTIN:
+		return "NOT IN"
+	case LIKE:
+		return "LIKE"
+	case ILIKE:
+		return "ILIKE"
+	}
 	for _, v := range tokens {
 		if tok == v.tok {
 			return string(v.seq)
@@ -60,17 +122,38 @@ func repr(tok token) string {
 	return "unknown token"
 }
 
-var prec = map[token]int{
-	MUL: 4,
-	DIV: 4,
-	ADD: 3,
-	SUB: 3,
-	EQ:  2,
-	NEQ: 2,
-	LT:  2,
-	LTE: 2,
-	GT:  2,
-	GTE: 2,
-	AND: 1,
-	OR:  1,
+const (
+	condPrec    = 0  // conditional operator binds weaker than any binary operator
+	unaryPrec   = 8  // unary operators bind tighter than any binary operator except ^
+	operandPrec = 10 // identifiers, literals, calls and anything in parentheses
+)
+
+// precedence of binary operators, higher binds tighter. All associate to the left except
+// ^ and ??, see rightAssoc.
+var prec = map[Token]int{
+	POW:      9,
+	MUL:      7,
+	DIV:      7,
+	MOD:      7,
+	ADD:      6,
+	SUB:      6,
+	CONCAT:   6,
+	LT:       5,
+	LTE:      5,
+	GT:       5,
+	GTE:      5,
+	IN:       5,
+	NOTIN:    5,
+	LIKE:     5,
+	ILIKE:    5,
+	EQ:       4,
+	NEQ:      4,
+	AND:      3,
+	OR:       2,
+	COALESCE: 1,
+}
+
+// rightAssoc returns whether operator associates to the right, a ^ b ^ c is a ^ (b ^ c)
+func rightAssoc(op Token) bool {
+	return op == POW || op == COALESCE
 }
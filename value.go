@@ -0,0 +1,165 @@
+package eval
+
+import (
+	"database/sql/driver"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// FloatMode selects conversion of Go floats to numbers, see SetFloatMode
+type FloatMode int
+
+const (
+	FloatExact    FloatMode = iota // exact binary value, 0.1 is 0.1000000000000000055511151231257827..., the default
+	FloatShortest                  // shortest decimal converted back to the same float, 0.1 is 0.1
+	FloatHalfUp                    // exact value rounded to places, halves away from zero
+	FloatHalfEven                  // exact value rounded to places, halves to even last digit
+	FloatDown                      // exact value truncated to places toward zero
+)
+
+// normalize converts Go value to value of expression: nil, string, *big.Rat, bool,
+// time.Time, []interface{} or record. It returns false if there is no such value.
+// Integers and floats of any kind are numbers, json.Number too. Values of driver.Valuer
+// like sql.NullString are converted, encoding.TextMarshaler and []byte give strings.
+// Named numeric, string and bool types give their underlying values even if they are
+// fmt.Stringer, so enum of int type is number. Other fmt.Stringer give strings. Pointers
+// are dereferenced, nil pointer is null, slices and arrays are lists.
+func normalize(v interface{}, context *context) (interface{}, bool) {
+	switch x := v.(type) {
+	case nil, string, bool, *big.Rat, time.Time:
+		if r, ok := x.(*big.Rat); ok && r == nil {
+			return nil, true
+		}
+		return v, true
+	case json.Number:
+		return new(big.Rat).SetString(string(x))
+	case *big.Int:
+		if x == nil {
+			return nil, true
+		}
+		return new(big.Rat).SetInt(x), true
+	case *big.Float:
+		if x == nil {
+			return nil, true
+		}
+		if x.IsInf() {
+			return nil, false
+		}
+		r, _ := x.Rat(nil)
+		return r, true
+	case *time.Time:
+		if x == nil {
+			return nil, true
+		}
+		return *x, true
+	case []byte:
+		if x == nil {
+			return nil, true
+		}
+		return string(x), true
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr && rv.IsNil() {
+		return nil, true
+	}
+	if x, ok := v.(driver.Valuer); ok {
+		dv, err := x.Value()
+		if err != nil {
+			return nil, false
+		}
+		return normalize(dv, context)
+	}
+	if x, ok := v.(encoding.TextMarshaler); ok {
+		b, err := x.MarshalText()
+		return string(b), err == nil
+	}
+	if rv.Kind() == reflect.Ptr && !isRecord(v) {
+		return normalize(rv.Elem().Interface(), context)
+	}
+	if isRecord(v) {
+		return v, true
+	}
+	switch rv.Kind() {
+	case reflect.Bool:
+		return rv.Bool(), true
+	case reflect.String:
+		return rv.String(), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return new(big.Rat).SetInt64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return new(big.Rat).SetInt(new(big.Int).SetUint64(rv.Uint())), true
+	case reflect.Float32, reflect.Float64:
+		return context.floatNumber(rv.Float(), rv.Type().Bits())
+	}
+	if x, ok := v.(fmt.Stringer); ok {
+		return x.String(), true
+	}
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		if rv.Kind() == reflect.Slice && rv.IsNil() {
+			return nil, true
+		}
+		list := make([]interface{}, rv.Len())
+		for i := range list {
+			e, ok := normalize(rv.Index(i).Interface(), context)
+			if !ok {
+				return nil, false
+			}
+			list[i] = e
+		}
+		return list, true
+	}
+	return nil, false
+}
+
+// floatNumber converts float of the size in bits to number according to float mode of
+// the context, infinities and NaN are not numbers
+func (context *context) floatNumber(f float64, bits int) (interface{}, bool) {
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return nil, false
+	}
+	switch context.floatMode {
+	case FloatShortest:
+		return shortestRat(f, bits)
+	case FloatHalfUp, FloatHalfEven, FloatDown:
+		return roundRat(new(big.Rat).SetFloat64(f), context.floatPlaces, context.floatMode), true
+	}
+	return new(big.Rat).SetFloat64(f), true
+}
+
+// shortestRat converts float of the size in bits to number by its shortest decimal
+// representation
+func shortestRat(f float64, bits int) (*big.Rat, bool) {
+	return new(big.Rat).SetString(strconv.FormatFloat(f, 'g', -1, bits))
+}
+
+// roundRat rounds x to places after the decimal point by rounding mode FloatHalfUp,
+// FloatHalfEven or FloatDown. Negative places round to tens, hundreds and so on.
+func roundRat(x *big.Rat, places int, mode FloatMode) *big.Rat {
+	e := new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(abs(places))), nil))
+	if places < 0 {
+		e.Inv(e)
+	}
+	r := new(big.Rat).Mul(x, e)
+	q, m := new(big.Int).QuoRem(r.Num(), r.Denom(), new(big.Int)) // q is truncated
+	if mode != FloatDown && m.Sign() != 0 {
+		half := new(big.Int).Lsh(m.Abs(m), 1).Cmp(r.Denom())
+		if half > 0 || half == 0 && (mode == FloatHalfUp || q.Bit(0) == 1) {
+			q.Add(q, big.NewInt(int64(x.Sign())))
+		}
+	}
+	return r.SetInt(q).Quo(r, e)
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,142 @@
+package eval
+
+// A Visitor's Visit method is invoked for each node encountered by Walk. If the result
+// visitor w is not nil, Walk visits each of the children of node with the visitor w,
+// followed by a call of w.Visit(nil).
+type Visitor interface {
+	Visit(node Expr) (w Visitor)
+}
+
+// Walk traverses expression tree in depth-first order: it starts by calling v.Visit(node),
+// node must not be nil. Function name of a Call is not visited as a node, use Name of
+// the call instead.
+func Walk(v Visitor, node Expr) {
+	if v = v.Visit(node); v == nil {
+		return
+	}
+	switch n := node.(type) {
+	case *Ident, *Literal, *BadExpr:
+		// nothing to do
+	case *Call:
+		for _, a := range n.args {
+			Walk(v, a)
+		}
+	case *List:
+		for _, e := range n.elems {
+			Walk(v, e)
+		}
+	case *Selector:
+		Walk(v, n.x)
+	case *Index:
+		Walk(v, n.x)
+		Walk(v, n.index)
+	case *Unary:
+		Walk(v, n.x)
+	case *Binary:
+		Walk(v, n.x)
+		Walk(v, n.y)
+	case *Like:
+		Walk(v, n.x)
+		Walk(v, n.pattern)
+		if n.escape != nil {
+			Walk(v, n.escape)
+		}
+	case *Lambda:
+		Walk(v, n.body)
+	case *Script:
+		for _, s := range n.stmts {
+			Walk(v, s)
+		}
+	case *Assign:
+		Walk(v, n.x)
+	case *Conditional:
+		Walk(v, n.cond)
+		Walk(v, n.then)
+		Walk(v, n.els)
+	}
+	v.Visit(nil)
+}
+
+// FreeIdentifiers returns names of identifiers resolved by values of context, in order of
+// their first occurrence. Parameters of lambdas, names bound by LET and names assigned in
+// scripts are not free in their scopes. For selectors like Account.Name, name of the
+// identifier Account is returned.
+func FreeIdentifiers(e Expr) []string {
+	v := &freeVisitor{bound: make(map[string]int), seen: make(map[string]bool)}
+	Walk(v, e)
+	return v.names
+}
+
+type freeVisitor struct {
+	bound map[string]int // number of scopes binding the name
+	seen  map[string]bool
+	names []string
+}
+
+func (v *freeVisitor) bind(names []string, n int) {
+	for _, name := range names {
+		v.bound[name] += n
+	}
+}
+
+func (v *freeVisitor) Visit(node Expr) Visitor {
+	switch n := node.(type) {
+	case *Ident:
+		if v.bound[n.name] == 0 && !v.seen[n.name] {
+			v.seen[n.name] = true
+			v.names = append(v.names, n.name)
+		}
+	case *Lambda:
+		v.bind(n.params, 1)
+		Walk(v, n.body)
+		v.bind(n.params, -1)
+		return nil
+	case *Script:
+		assigned := make(map[string]bool)
+		for _, s := range n.stmts {
+			Walk(v, s)
+			if a, ok := s.(*Assign); ok && !assigned[a.name] {
+				assigned[a.name] = true
+				v.bind([]string{a.name}, 1)
+			}
+		}
+		for name := range assigned {
+			v.bind([]string{name}, -1)
+		}
+		return nil
+	case *Call:
+		if n.name != "LET" || len(n.args)%2 == 0 {
+			return v
+		}
+		var names []string
+		for i := 0; i < len(n.args)-1; i += 2 {
+			if name, ok := n.args[i].(*Ident); ok {
+				Walk(v, n.args[i+1])
+				v.bind([]string{name.name}, 1)
+				names = append(names, name.name)
+			}
+		}
+		Walk(v, n.args[len(n.args)-1])
+		v.bind(names, -1)
+		return nil
+	case nil:
+		return nil
+	}
+	return v
+}
+
+type inspector func(Expr) bool
+
+func (f inspector) Visit(node Expr) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+// Inspect traverses expression tree in depth-first order: it starts by calling f(node),
+// node must not be nil. If f returns true, Inspect invokes f recursively for each of the
+// children of node, followed by a call of f(nil).
+func Inspect(node Expr, f func(Expr) bool) {
+	Walk(inspector(f), node)
+}
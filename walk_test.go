@@ -0,0 +1,111 @@
+package eval
+
+import (
+	"fmt"
+	"math/big"
+	"testing"
+)
+
+func TestInspect(t *testing.T) {
+	e, err := ParseString("if(len(Name) > 3 && !IsActive, left(Name, 3), -Amount * 2)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var idents, calls []string
+	var literals []interface{}
+	depth, maxDepth := 0, 0
+	Inspect(e, func(node Expr) bool {
+		if node == nil {
+			depth--
+			return false
+		}
+		if depth++; depth > maxDepth {
+			maxDepth = depth
+		}
+		switch x := node.(type) {
+		case *Ident:
+			idents = append(idents, x.Name())
+		case *Call:
+			calls = append(calls, x.Name())
+		case *Literal:
+			literals = append(literals, numberString(x.Value().(*big.Rat)))
+		}
+		return true
+	})
+	if s := fmt.Sprint(idents); s != "[Name IsActive Name Amount]" {
+		t.Error("wrong identifiers:", s)
+	}
+	if s := fmt.Sprint(calls); s != "[IF LEN LEFT]" {
+		t.Error("wrong calls:", s)
+	}
+	if s := fmt.Sprint(literals); s != "[3 3 2]" {
+		t.Error("wrong literals:", s)
+	}
+	if depth != 0 || maxDepth != 5 {
+		t.Error("wrong depth:", depth, maxDepth)
+	}
+}
+
+type countVisitor map[string]int
+
+func (v countVisitor) Visit(node Expr) Visitor {
+	if node != nil {
+		v[fmt.Sprintf("%T", node)]++
+	}
+	if _, ok := node.(*Call); ok {
+		return nil // do not descend into function arguments
+	}
+	return v
+}
+
+func TestWalk(t *testing.T) {
+	e, _ := ParseString("a + f(b, c) * -d")
+	v := countVisitor{}
+	Walk(v, e)
+	if s := fmt.Sprint(map[string]int(v)); s != "map[*eval.Binary:2 *eval.Call:1 *eval.Ident:2 *eval.Unary:1]" {
+		t.Error("wrong nodes visited:", s)
+	}
+	b := e.(*Binary)
+	if b.Op() != ADD || b.X().(*Ident).Name() != "a" || b.Y().(*Binary).Y().(*Unary).Op() != SUB {
+		t.Error("wrong accessors of:", e)
+	}
+	if args := b.Y().(*Binary).X().(*Call).Args(); len(args) != 2 {
+		t.Error("wrong call arguments:", args)
+	}
+}
+
+func TestFreeIdentifiers(t *testing.T) {
+	tests := []struct {
+		src   string
+		names string
+	}{
+		{"a + b * a", "[a b]"},
+		{"Account.Owner.Name & Name", "[Account Name]"},
+		{"LET(t, TRIM(x), IF(LEN(t) > 10, LEFT(t, 10), t))", "[x]"},
+		{"LET(a, b, c, a + c + d, c * e)", "[b c d e]"},
+		{"LET(a, a, a)", "[a]"},
+		{"t + LET(t, 1, t)", "[t]"},
+		{"MAP(items, x -> x.amount * rate) + x", "[items rate x]"},
+		{"REDUCE(l, 0, (acc, x) -> LET(y, acc, y + x + z))", "[l z]"},
+	}
+	for _, test := range tests {
+		e, err := Salesforce.ParseString(test.src)
+		if err != nil {
+			t.Error("failed to parse:", test.src, err)
+			continue
+		}
+		if s := fmt.Sprint(FreeIdentifiers(e)); s != test.names {
+			t.Error("wrong free identifiers of:", test.src, "expected:", test.names, "actual:", s)
+		}
+	}
+}
+
+func TestFreeIdentifiersOfScript(t *testing.T) {
+	s, err := ParseScript([]byte("a := b + a\nc := a * d\nc + e"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := fmt.Sprint(FreeIdentifiers(s)); names != "[b a d e]" {
+		t.Error("wrong free identifiers of script:", names)
+	}
+}